@@ -0,0 +1,172 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package exportertest provides a stand-in for windows_exporter serving scripted metrics, so the
+// scrape loop can be tested without running the exporter.
+package exportertest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricPath is the path where the metrics are served, as the exporter does
+const MetricPath = "/metrics"
+
+var (
+	states     = []string{"continue pending", "pause pending", "paused", "running", "start pending", "stop pending", "stopped", "unknown"}
+	startModes = []string{"auto", "boot", "disabled", "manual", "system"}
+)
+
+// Service is a service reported in the metrics served
+type Service struct {
+	Name        string
+	DisplayName string // defaults to Name
+	State       string // defaults to running
+	StartMode   string // defaults to auto
+	RunAs       string
+	ProcessID   int // the process metric is only served when it's not 0
+}
+
+// Response is a scripted answer to a scrape
+type Response struct {
+	Status int // defaults to 200
+	Body   string
+	// Delay is waited before answering, unless the request is cancelled
+	Delay time.Duration
+}
+
+// OK returns a response with the metrics of the services
+func OK(services ...Service) Response {
+	return Response{Body: Metrics(services...)}
+}
+
+// Slow returns a response with the metrics of the services sent after the delay
+func Slow(delay time.Duration, services ...Service) Response {
+	return Response{Body: Metrics(services...), Delay: delay}
+}
+
+// Error returns a response with the given status code
+func Error(status int) Response {
+	return Response{Status: status, Body: http.StatusText(status)}
+}
+
+// Malformed returns a response whose body is not valid in the Prometheus text format
+func Malformed() Response {
+	return Response{Body: "# TYPE windows_service_state gauge\nwindows_service_state{name=\"spooler\",state=\"running\" 1\n"}
+}
+
+// Server serves the scripted responses in order, repeating the last one once all have been served.
+type Server struct {
+	*httptest.Server
+	lock      sync.Mutex
+	responses []Response
+	requests  int
+}
+
+// New starts a Server, it must be closed by the caller
+func New(responses ...Response) *Server {
+	s := &Server{responses: responses}
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricPath, s.serve)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// MetricsURL returns the URL to scrape
+func (s *Server) MetricsURL() string {
+	return s.URL + MetricPath
+}
+
+// Requests returns how many scrapes have been received
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	resp := Error(http.StatusNotFound)
+	if len(s.responses) > 0 {
+		idx := s.requests
+		if idx >= len(s.responses) {
+			idx = len(s.responses) - 1
+		}
+		resp = s.responses[idx]
+	}
+	s.requests++
+	s.lock.Unlock()
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if resp.Status != 0 {
+		w.WriteHeader(resp.Status)
+	}
+	fmt.Fprint(w, resp.Body)
+}
+
+// Metrics renders the services metrics in the windows_exporter text format
+func Metrics(services ...Service) string {
+	var b strings.Builder
+	b.WriteString("# HELP windows_service_info A metric with a constant '1' value labeled with service information\n")
+	b.WriteString("# TYPE windows_service_info gauge\n")
+	for _, s := range services {
+		displayName := s.DisplayName
+		if displayName == "" {
+			displayName = s.Name
+		}
+		fmt.Fprintf(&b, "windows_service_info{display_name=%q,name=%q,run_as=%q} 1\n", displayName, s.Name, s.RunAs)
+	}
+
+	b.WriteString("# HELP windows_service_process Process of started service\n")
+	b.WriteString("# TYPE windows_service_process gauge\n")
+	for _, s := range services {
+		if s.ProcessID != 0 {
+			fmt.Fprintf(&b, "windows_service_process{name=%q,process_id=\"%d\"} 1\n", s.Name, s.ProcessID)
+		}
+	}
+
+	b.WriteString("# HELP windows_service_start_mode The start mode of the service (StartMode)\n")
+	b.WriteString("# TYPE windows_service_start_mode gauge\n")
+	for _, s := range services {
+		writeEnum(&b, "windows_service_start_mode", "start_mode", s.Name, startModes, valueOr(s.StartMode, "auto"))
+	}
+
+	b.WriteString("# HELP windows_service_state The state of the service (State)\n")
+	b.WriteString("# TYPE windows_service_state gauge\n")
+	for _, s := range services {
+		writeEnum(&b, "windows_service_state", "state", s.Name, states, valueOr(s.State, "running"))
+	}
+	return b.String()
+}
+
+// writeEnum writes a line for every value, set to 1 only for the active one
+func writeEnum(b *strings.Builder, metric, label, name string, values []string, active string) {
+	for _, v := range values {
+		value := 0
+		if v == active {
+			value = 1
+		}
+		fmt.Fprintf(b, "%s{name=%q,%s=%q} %d\n", metric, name, label, v, value)
+	}
+}
+
+func valueOr(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,37 @@
+//go:build windows && amd64
+// +build windows,amd64
+
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"github.com/newrelic/nri-winservices/src/exporter"
+	"github.com/newrelic/nri-winservices/src/nri"
+	"github.com/newrelic/nri-winservices/src/source"
+
+	//This import is useful merely to keep track of dependency and generate license automatically
+	_ "github.com/prometheus-community/windows_exporter/collector"
+)
+
+// newLocalSource creates the source the services of the host are collected from
+func newLocalSource(config *nri.Config) (source.MetricsSource, error) {
+	switch config.MetricsSource {
+	case nri.MetricsSourceSCM:
+		return source.NewSCM(), nil
+	case nri.MetricsSourceFile:
+		return source.NewFileReplay(config.ReplayFiles...), nil
+	}
+	port, err := config.ExporterPort()
+	if err != nil {
+		return nil, err
+	}
+	e, err := exporter.New(args.Verbose, config.ExporterBindAddress, port)
+	if err != nil {
+		return nil, err
+	}
+	return source.NewExporter(e), nil
+}
@@ -0,0 +1,25 @@
+//go:build !(windows && amd64)
+// +build !windows !amd64
+
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/newrelic/nri-winservices/src/nri"
+	"github.com/newrelic/nri-winservices/src/source"
+)
+
+// newLocalSource creates the source the services of the host are collected from. Outside Windows
+// there are no services to collect, only the replay of saved exporter outputs is available.
+func newLocalSource(config *nri.Config) (source.MetricsSource, error) {
+	if config.MetricsSource != nri.MetricsSourceFile {
+		return nil, fmt.Errorf("the %s metrics_source is only supported on windows", config.MetricsSource)
+	}
+	return source.NewFileReplay(config.ReplayFiles...), nil
+}
@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,82 +6,142 @@
 package matcher
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/newrelic/infra-integrations-sdk/v4/log"
 )
 
 // Matcher groups the rules to validate the service name
 type Matcher struct {
-	includePatterns []pattern
-	excludePatterns []pattern
+	includePatterns patterns
+	excludePatterns patterns
+	// cache memoizes the result per service name. It is shared by the copies of the Matcher,
+	// and since a new Matcher is built when the config is loaded it never holds stale results.
+	cache *matchCache
+}
+
+// patterns holds the literal filters in a set with their case folded form and all the regex
+// filters combined in a single alternation.
+type patterns struct {
+	literals map[string]struct{}
+	regex    *regexp.Regexp
 }
-type pattern struct {
-	regex *regexp.Regexp
+
+// PatternError describes a filter that couldn't be compiled. List is "include" or "exclude"
+// and Index the position of the filter in that list.
+type PatternError struct {
+	List   string
+	Index  int
+	Filter string
+	Err    error
+}
+
+func (e PatternError) Error() string {
+	return fmt.Sprintf("%s[%d] %q: %v", e.List, e.Index, e.Filter, e.Err)
+}
+
+// InvalidPatternsError lists every filter that couldn't be compiled
+type InvalidPatternsError []PatternError
+
+func (e InvalidPatternsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, p := range e {
+		msgs[i] = p.Error()
+	}
+	return "invalid filters: " + strings.Join(msgs, "; ")
+}
+
+type matchCache struct {
+	sync.RWMutex
+	results map[string]bool
 }
 
 // Match returns true if the string matches include patterns and doesn't match exclude patterns
 // Include patterns are required - this matcher does not support exclude-only filtering
 func (m *Matcher) Match(s string) bool {
-	// Must match at least one include pattern first
-	includeMatch := false
-	for _, p := range m.includePatterns {
-		if p.match(s) {
-			includeMatch = true
-			break
-		}
+	if m.cache == nil {
+		return m.match(s)
 	}
 
-	// If no include patterns match, return false
-	if !includeMatch {
-		return false
+	m.cache.RLock()
+	result, ok := m.cache.results[s]
+	m.cache.RUnlock()
+	if ok {
+		return result
 	}
 
-	// Check if it matches any exclude patterns (exclude takes precedence)
-	for _, p := range m.excludePatterns {
-		if p.match(s) {
-			return false
-		}
+	result = m.match(s)
+	m.cache.Lock()
+	m.cache.results[s] = result
+	m.cache.Unlock()
+	return result
+}
+
+func (m *Matcher) match(s string) bool {
+	// Must match at least one include pattern first
+	if !m.includePatterns.match(s) {
+		return false
 	}
 
-	return true
+	// Check if it matches any exclude patterns (exclude takes precedence)
+	return !m.excludePatterns.match(s)
 }
 
 // IsEmpty returns true if the Matcher has no include patterns
 // (exclude patterns alone are not sufficient for a valid matcher)
 func (m *Matcher) IsEmpty() bool {
-	return len(m.includePatterns) == 0
+	return m.includePatterns.isEmpty()
 }
 
-func (p pattern) match(s string) bool {
-	return p.regex.MatchString(s)
+func (p patterns) match(s string) bool {
+	if _, ok := p.literals[fold(s)]; ok {
+		return true
+	}
+	return p.regex != nil && p.regex.MatchString(s)
+}
+
+func (p patterns) isEmpty() bool {
+	return len(p.literals) == 0 && p.regex == nil
 }
 
 // New create a new Matcher instance from slices of include and exclude filters
-func New(includeFilters []string) Matcher {
+func New(includeFilters []string) (Matcher, error) {
 	return NewWithIncludesExcludes(includeFilters, nil)
 }
 
 // NewWithExcludes creates a new Matcher instance with both include and exclude filters
 // (regex) "<filter>"
-func NewWithIncludesExcludes(includeFilters, excludeFilters []string) Matcher {
+// When some filters are not valid an InvalidPatternsError is returned together with a Matcher
+// built from the valid ones, so callers can choose to go on without them.
+func NewWithIncludesExcludes(includeFilters, excludeFilters []string) (Matcher, error) {
 	var m Matcher
+	var invalid InvalidPatternsError
 
-	m.includePatterns = buildPatterns(includeFilters)
-	m.excludePatterns = buildPatterns(excludeFilters)
+	m.includePatterns, invalid = buildPatterns("include", includeFilters, invalid)
+	m.excludePatterns, invalid = buildPatterns("exclude", excludeFilters, invalid)
+	m.cache = &matchCache{results: make(map[string]bool)}
 
-	return m
+	if len(invalid) > 0 {
+		return m, invalid
+	}
+	return m, nil
 }
 
-// buildPatterns creates patterns from filter strings
-func buildPatterns(filters []string) []pattern {
-	var patterns []pattern
-	r, _ := regexp.Compile("(regex)?.?\"(.+)\"")
+// buildPatterns creates patterns from filter strings. Besides literals, filters can be written as
+// regex "<regex>", glob "<glob>" or prefix "<prefix>". The filters that fail to compile are
+// appended to invalid.
+func buildPatterns(list string, filters []string, invalid InvalidPatternsError) (patterns, InvalidPatternsError) {
+	p := patterns{literals: make(map[string]struct{})}
+	var regexFilters []string
+	r, _ := regexp.Compile("(regex|glob|prefix)?.?\"(.+)\"")
 
-	for _, line := range filters {
-		var p pattern
+	for idx, line := range filters {
 		var filter string
-		var isRegex bool
+		var kind string
 
 		if line == "" {
 			log.Debug("filter line empty")
@@ -92,10 +149,8 @@ func buildPatterns(filters []string) []pattern {
 		}
 
 		if s := r.FindStringSubmatch(line); s != nil {
-			// s[1] -> (regex)
-			if s[1] != "" {
-				isRegex = true
-			}
+			// s[1] -> (regex|glob|prefix)
+			kind = s[1]
 			// s[2] -> \"(.+)\"
 			if s[2] != "" {
 				filter = s[2]
@@ -104,19 +159,77 @@ func buildPatterns(filters []string) []pattern {
 			filter = line
 		}
 
-		// if the filter is not a regex all special regex characters are escaped
-		if !isRegex {
-			filter = "^" + regexp.QuoteMeta(filter) + "$"
+		switch kind {
+		case "":
+			// literal filters are compared case insensitively, as the (?i) regex patterns
+			log.Debug("pattern added literal: %v ", filter)
+			p.literals[fold(filter)] = struct{}{}
+			continue
+		case "glob":
+			filter = globToRegex(filter)
+		case "prefix":
+			filter = "^" + regexp.QuoteMeta(filter)
 		}
-		// windows services names are collected in lower case, (?i) is the case insensitive flag
-		reg, err := regexp.Compile("(?i)" + filter)
-		if err != nil {
-			log.Warn("failed to compile regex:%s err:%v", reg, err)
+
+		// each regex is validated on its own so an invalid one doesn't discard the others
+		if _, err := regexp.Compile(filter); err != nil {
+			invalid = append(invalid, PatternError{List: list, Index: idx, Filter: line, Err: err})
 			continue
 		}
-		log.Debug("pattern added regex: %v ", filter)
-		p.regex = reg
-		patterns = append(patterns, p)
+		log.Debug("pattern added %s: %v ", kind, filter)
+		regexFilters = append(regexFilters, "(?:"+filter+")")
+	}
+
+	if len(regexFilters) > 0 {
+		// (?i) is the case insensitive flag
+		p.regex = regexp.MustCompile("(?i)" + strings.Join(regexFilters, "|"))
+	}
+	return p, invalid
+}
+
+// fold maps every rune to the smallest one equivalent under Unicode simple case folding, the
+// same equivalence used by the (?i) flag, so strings differing only in case get the same key.
+func fold(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
+
+// globToRegex translates a glob into an anchored regex. '*' matches any sequence of characters,
+// '?' matches a single character and '[...]' a character class, negated when starting with '!'.
+// A class that is not closed is kept as it is, so the regex fails to compile.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(glob[i:])
+				i = len(glob)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
 	}
-	return patterns
+	b.WriteString("$")
+	return b.String()
 }
@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,9 +6,16 @@
 package matcher
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMatcherMatch(t *testing.T) {
@@ -25,7 +29,8 @@ func TestMatcherMatch(t *testing.T) {
 		`"quoted"`,
 	}
 
-	m := New(filterList)
+	m, err := New(filterList)
+	require.NoError(t, err)
 
 	assert.True(t, m.Match("customimportantservice"))
 	assert.True(t, m.Match("special.?^ServiceWithSpecialChars"))
@@ -45,7 +50,8 @@ func TestMatcherWithExcludes(t *testing.T) {
 		`regex "^(Themes|Spooler)$"`,
 	}
 
-	m := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	m, err := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	require.NoError(t, err)
 
 	// Should include services that match include but not exclude
 	assert.True(t, m.Match("newrelic-infra"))
@@ -70,7 +76,8 @@ func TestMatcherWithExcludesOnly(t *testing.T) {
 		`"ServiceA"`,
 	}
 
-	m := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	m, err := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	require.NoError(t, err)
 
 	// ServiceA should be excluded even though it's in include list
 	assert.False(t, m.Match("ServiceA"))
@@ -93,7 +100,8 @@ func TestMatcherBothIncludeAndExclude(t *testing.T) {
 		`regex ".*Audio.*"`, // Exclude any audio-related services
 	}
 
-	m := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	m, err := NewWithIncludesExcludes(includeFilters, excludeFilters)
+	require.NoError(t, err)
 
 	// Should include: matches include pattern and doesn't match exclude
 	assert.True(t, m.Match("Windows Defender"))
@@ -118,7 +126,8 @@ func TestMatcherOnlyIncludeFilters(t *testing.T) {
 		`regex "^CustomService.*$"`,
 	}
 
-	m := NewWithIncludesExcludes(includeFilters, nil)
+	m, err := NewWithIncludesExcludes(includeFilters, nil)
+	require.NoError(t, err)
 
 	// Should match services in the include list
 	assert.True(t, m.Match("newrelic-infra"))
@@ -133,3 +142,256 @@ func TestMatcherOnlyIncludeFilters(t *testing.T) {
 
 // Removed TestMatcherOnlyExcludeFilters because exclude-only filtering is not supported
 // Include patterns are always required for proper filtering behavior
+
+func TestMatcherCombinedRegexKeepsEachPattern(t *testing.T) {
+	m, err := New([]string{
+		`regex "^sql$"`,
+		`regex "agent"`,
+		`"Spooler"`,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, m.Match("SQL"))
+	assert.True(t, m.Match("sqlagent"))
+	assert.True(t, m.Match("spooler"))
+	// anchors of one alternative don't apply to the others
+	assert.False(t, m.Match("mysql"))
+	assert.False(t, m.Match("spooler2"))
+}
+
+func TestMatcherCachedResults(t *testing.T) {
+	m, err := NewWithIncludesExcludes([]string{`regex "^win.*"`}, []string{`"WinRM"`})
+	require.NoError(t, err)
+	c := m
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, m.Match("Winmgmt"))
+		assert.False(t, m.Match("WinRM"))
+		assert.False(t, m.Match("Spooler"))
+	}
+	// copies of the matcher share the cached results
+	assert.Len(t, c.cache.results, 3)
+
+	// a new matcher, as built on config reload, doesn't reuse previous results
+	m, err = New([]string{`"Spooler"`})
+	require.NoError(t, err)
+	assert.False(t, m.Match("Winmgmt"))
+	assert.True(t, m.Match("Spooler"))
+}
+
+// linearMatcher compiles every filter to its own regex and tries them one by one,
+// it's used as baseline by the benchmarks.
+type linearMatcher []*regexp.Regexp
+
+func newLinearMatcher(filters []string) linearMatcher {
+	var l linearMatcher
+	r := regexp.MustCompile("(regex)?.?\"(.+)\"")
+	for _, line := range filters {
+		s := r.FindStringSubmatch(line)
+		filter := s[2]
+		if s[1] == "" {
+			filter = "^" + regexp.QuoteMeta(filter) + "$"
+		}
+		l = append(l, regexp.MustCompile("(?i)"+filter))
+	}
+	return l
+}
+
+func (l linearMatcher) Match(s string) bool {
+	for _, r := range l {
+		if r.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func benchmarkFilters() (filters []string, services []string) {
+	for i := 0; i < 300; i++ {
+		filters = append(filters, fmt.Sprintf(`"Service%03d"`, i))
+		services = append(services, fmt.Sprintf("service%03d", i*2))
+	}
+	for i := 0; i < 20; i++ {
+		filters = append(filters, fmt.Sprintf(`regex "^App%02d.*"`, i))
+		services = append(services, fmt.Sprintf("app%02dworker", i*2))
+	}
+	return filters, services
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	filters, services := benchmarkFilters()
+	m := newLinearMatcher(filters)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range services {
+			m.Match(s)
+		}
+	}
+}
+
+func BenchmarkMatchUncached(b *testing.B) {
+	filters, services := benchmarkFilters()
+	m, err := New(filters)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range services {
+			m.match(s)
+		}
+	}
+}
+
+func BenchmarkMatchCached(b *testing.B) {
+	filters, services := benchmarkFilters()
+	m, err := New(filters)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range services {
+			m.Match(s)
+		}
+	}
+}
+
+// testdataServices returns the names of the services found in the exporter output used by the scraper tests
+func testdataServices(t *testing.T) []string {
+	content, err := ioutil.ReadFile("../scraper/testdata/actualOutput")
+	require.NoError(t, err)
+
+	var services []string
+	seen := make(map[string]bool)
+	r := regexp.MustCompile(`windows_service_state{name="([^"]+)"`)
+	for _, s := range r.FindAllStringSubmatch(string(content), -1) {
+		if !seen[s[1]] {
+			seen[s[1]] = true
+			services = append(services, s[1])
+		}
+	}
+	require.NotEmpty(t, services)
+	return services
+}
+
+func TestMatcherFilterForms(t *testing.T) {
+	services := testdataServices(t)
+
+	tests := []struct {
+		name     string
+		filter   string
+		expected []string
+	}{
+		{"literal", `"WinRM"`, []string{"winrm"}},
+		{"regex", `regex "^CDP.*"`, []string{"cdpsvc", "cdpusersvc_390e7"}},
+		{"prefix", `prefix "WPN"`, []string{"wpnservice", "wpnuserservice_390e7"}},
+		{"glob star", `glob "WPN*"`, []string{"wpnservice", "wpnuserservice_390e7"}},
+		{"glob question mark", `glob "W?CSVC"`, []string{"wecsvc", "wscsvc"}},
+		{"glob class", `glob "wd[in]*"`, []string{"wdiservicehost", "wdisystemhost", "wdnissvc"}},
+		{"glob negated class", `glob "wd[!n]*"`, []string{"wdiservicehost", "wdisystemhost"}},
+		{"glob class range", `glob "w[h-j]n*"`, []string{"windefend", "winhttpautoproxysvc", "winmgmt", "winrm"}},
+		{"glob is anchored", `glob "cdp*svc"`, []string{"cdpsvc"}},
+		{"glob special chars", `glob "*.svc"`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New([]string{tt.filter})
+			require.NoError(t, err)
+			var matched []string
+			for _, s := range services {
+				if m.Match(s) {
+					matched = append(matched, s)
+				}
+			}
+			assert.ElementsMatch(t, tt.expected, matched)
+		})
+	}
+}
+
+func TestMatcherGlobAndPrefixAsExcludes(t *testing.T) {
+	m, err := NewWithIncludesExcludes([]string{`glob "*"`}, []string{`glob "*_390e7"`, `prefix "W"`})
+	require.NoError(t, err)
+
+	assert.True(t, m.Match("cdpsvc"))
+	assert.False(t, m.Match("cdpusersvc_390e7"))
+	assert.False(t, m.Match("WinRM"))
+}
+
+func TestMatcherInvalidPatterns(t *testing.T) {
+	m, err := NewWithIncludesExcludes(
+		[]string{`"Spooler"`, `regex "[invalid"`, `regex "^win.*"`, `glob "wd[in*"`},
+		[]string{`regex "(unclosed"`, `"WinRM"`},
+	)
+
+	var invalid InvalidPatternsError
+	require.True(t, errors.As(err, &invalid))
+	require.Len(t, invalid, 3)
+	assert.Equal(t, "include", invalid[0].List)
+	assert.Equal(t, 1, invalid[0].Index)
+	assert.Equal(t, `regex "[invalid"`, invalid[0].Filter)
+	assert.Equal(t, "include", invalid[1].List)
+	assert.Equal(t, 3, invalid[1].Index)
+	assert.Equal(t, "exclude", invalid[2].List)
+	assert.Equal(t, 0, invalid[2].Index)
+	assert.Contains(t, err.Error(), `include[1] "regex \"[invalid\""`)
+	assert.Contains(t, err.Error(), `exclude[0] "regex \"(unclosed\""`)
+
+	// the valid filters are still loaded
+	assert.True(t, m.Match("spooler"))
+	assert.True(t, m.Match("winmgmt"))
+	assert.False(t, m.Match("winrm"))
+}
+
+func TestGlobToRegex(t *testing.T) {
+	assert.Equal(t, `^MSSQL.*$`, globToRegex("MSSQL*"))
+	assert.Equal(t, `^a.\.b$`, globToRegex("a?.b"))
+	assert.Equal(t, `^[^0-9]x$`, globToRegex("[!0-9]x"))
+	assert.Equal(t, `^a[bc$`, globToRegex("a[bc"))
+}
+
+func TestMatcherLiteralCaseFolding(t *testing.T) {
+	m, err := New([]string{`"ſvc"`, `"ΣΥΣ"`})
+	require.NoError(t, err)
+
+	// literals follow the same case folding as the (?i) regex patterns
+	assert.True(t, m.Match("SVC"))
+	assert.True(t, m.Match("ςυσ"))
+	assert.False(t, m.Match("svcs"))
+}
+
+// FuzzMatcherFilterLine checks that any filter line can be loaded and matched without panicking,
+// and that a literal filter matches the same names as its equivalent regex filter.
+func FuzzMatcherFilterLine(f *testing.F) {
+	seeds := []string{
+		`customImportantService`,
+		`"special.?^ServiceWithSpecialChars" #Comments`,
+		`regex "^Important.*$" #Comments`,
+		`regex`,
+		`regex .*`,
+		`.*`,
+		`"quoted"`,
+		`"Windows Update"`,
+		`regex "^(Themes|Spooler)$"`,
+		`glob "MSSQL*"`,
+		`glob "wd[!n]*"`,
+		`prefix "WPN"`,
+		`regex "[invalid"`,
+	}
+	for _, s := range seeds {
+		f.Add(s, "WindowsUpdate")
+	}
+
+	f.Fuzz(func(t *testing.T, line, name string) {
+		m, _ := NewWithIncludesExcludes([]string{line}, []string{line})
+		m.Match(name)
+
+		literal := strings.Trim(line, `"`)
+		if literal == "" || strings.ContainsAny(literal, "\"\n") || !utf8.ValidString(literal) || !utf8.ValidString(name) {
+			return
+		}
+		lm, err := New([]string{`"` + literal + `"`})
+		require.NoError(t, err)
+		rm, err := New([]string{`regex "^` + regexp.QuoteMeta(literal) + `$"`})
+		require.NoError(t, err)
+		assert.Equal(t, rm.Match(name), lm.Match(name), "literal %q name %q", literal, name)
+		assert.True(t, lm.Match(literal))
+	})
+}
@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,35 +6,173 @@
 package nri
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/v4/log"
 	"github.com/newrelic/nri-winservices/src/matcher"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/newrelic/nri-winservices/src/otlp"
+	"github.com/newrelic/nri-winservices/src/sink"
+	yaml "gopkg.in/yaml.v3"
 )
 
 const (
-	minScrapeInterval = 15 * time.Second
-	heartBeatPeriod   = 5 * time.Second // Period for the hard beat signal should be less than timeout
+	minScrapeInterval  = 15 * time.Second
+	heartBeatPeriod    = 5 * time.Second // Period for the hard beat signal should be less than timeout
+	defaultOTLPTimeout = 10 * time.Second
+	defaultSinkTimeout = 10 * time.Second
+	bytesPerMB         = 1024 * 1024
+
+	defaultPrometheusExportAddress = "127.0.0.1"
+	defaultPrometheusExportPath    = "/metrics"
+
+	defaultExporterBindAddress = "127.0.0.1"
+	// autoPort makes the integration pick a free port for the exporter, it's the default since the
+	// well known port of the exporter, 9182, is often taken by a standalone windows_exporter
+	autoPort                = "auto"
+	defaultExporterBindPort = autoPort
+
+	// Backends the local services are collected from
+	MetricsSourceExporter = "exporter"
+	MetricsSourceSCM      = "scm"
+	MetricsSourceFile     = "file"
+
+	// serviceNameFilterKey is the only metadata supported for filtering
+	serviceNameFilterKey = "windowsService.name"
 )
 
+// unknownKeyError matches the yaml strict mode errors for keys not defined in the config
+var unknownKeyError = regexp.MustCompile(`^line (\d+): field (.+) not found in type .+$`)
+
 // Config holds the integration configuration
 type Config struct {
 	Matcher             matcher.Matcher
 	ExporterBindAddress string
-	ExporterBindPort    string
-	ScrapeInterval      time.Duration
-	HeartBeatPeriod     time.Duration
+	// ExporterBindPort is auto when a free port is selected by ExporterPort
+	ExporterBindPort string
+	ScrapeInterval   time.Duration
+	// ScrapeJitter and ScrapeAlign delay the first scrape, see scheduler.Config
+	ScrapeJitter float64
+	ScrapeAlign  time.Duration
+	// MaxSkippedScrapes is the number of scrapes of the local services skipped in a row after which
+	// the integration stops, 0 never stops it
+	MaxSkippedScrapes uint64
+	HeartBeatPeriod   time.Duration
+	OTLP              *otlp.Config // nil when the otlp output is not configured
+	Sinks             []sink.Config
+	// PrometheusExportAddress is empty when the processed metrics are not served to Prometheus
+	PrometheusExportAddress string
+	PrometheusExportPath    string
+	ServiceTags             ServiceTags
+	DesiredStates           DesiredStates
+	EntityLimit             EntityLimit
+	// OmitMetadata lists the entity metadata left out of the payload
+	OmitMetadata []string
+	// CriticalServices are flagged when they are not restarted on failure, nil means the
+	// services starting automatically.
+	CriticalServices *matcher.Matcher
+	// EntityNameHost is the host part of the entity names. The Agent replaces localhost with the host name.
+	EntityNameHost string
+	// ScrapeLocal is false when only instances are configured, then the exporter is not spawned.
+	ScrapeLocal bool
+	// MetricsSource is the backend the local services are collected from, the spawned exporter,
+	// the Service Control Manager or exporter outputs saved to files.
+	MetricsSource string
+	// ReplayFiles are the exporter outputs replayed in order by the file metrics source.
+	ReplayFiles []string
+	// Instances scrape remote exporters, each one with its own filters.
+	Instances []*Config
+	// ExporterURL and Hostname are only set for instances.
+	ExporterURL string
+	Hostname    string
+	// droppedServices keeps the services dropped by EntityLimit across the cycles
+	droppedServices *droppedServices
+}
+
+// serviceConfigYml holds the options applied to the services of each exporter
+type serviceConfigYml struct {
+	IncludeEntity map[string][]string `yaml:"include_matching_entities"`
+	ExcludeEntity map[string][]string `yaml:"exclude_matching_entities"`
+	ServiceTags   []serviceTagsYml    `yaml:"service_tags"`
+	DesiredState  []desiredStateYml   `yaml:"desired_state"`
+	// LenientFilters logs the filters that fail to compile and ignores them instead of failing
+	LenientFilters  bool     `yaml:"lenient_filters"`
+	MaxEntities     int      `yaml:"max_entities"`
+	EntitySelection string   `yaml:"entity_selection"`
+	OmitMetadata    []string `yaml:"omit_metadata"`
+	// CriticalServices are filters, with the include_matching_entities syntax, of the services
+	// that must be restarted on failure
+	CriticalServices []string `yaml:"critical_services"`
 }
 
 type configYml struct {
-	IncludeEntity       map[string][]string `yaml:"include_matching_entities"`
-	ExcludeEntity       map[string][]string `yaml:"exclude_matching_entities"`
-	ExporterBindAddress string              `yaml:"exporter_bind_address"`
-	ExporterBindPort    string              `yaml:"exporter_bind_port"`
-	ScrapeInterval      string              `yaml:"scrape_interval"`
+	serviceConfigYml    `yaml:",inline"`
+	ExporterBindAddress string               `yaml:"exporter_bind_address"`
+	ExporterBindPort    string               `yaml:"exporter_bind_port"`
+	ScrapeInterval      string               `yaml:"scrape_interval"`
+	ScrapeJitter        float64              `yaml:"scrape_jitter"`
+	ScrapeAlign         string               `yaml:"scrape_align"`
+	MaxSkippedScrapes   uint64               `yaml:"max_skipped_scrapes"`
+	MetricsSource       string               `yaml:"metrics_source"`
+	ReplayFiles         []string             `yaml:"replay_files"`
+	OTLP                *otlpYml             `yaml:"otlp"`
+	Sinks               []sinkYml            `yaml:"sinks"`
+	PrometheusExport    *prometheusExportYml `yaml:"prometheus_export"`
+	Instances           []instanceYml        `yaml:"instances"`
+}
+
+type instanceYml struct {
+	serviceConfigYml `yaml:",inline"`
+	ExporterURL      string `yaml:"exporter_url"`
+	Hostname         string `yaml:"hostname"`
+	EntityNameHost   string `yaml:"entity_name_host"`
+	ScrapeInterval   string `yaml:"scrape_interval"`
+}
+
+type desiredStateYml struct {
+	Match     []string `yaml:"match"`
+	State     string   `yaml:"state"`
+	StartMode string   `yaml:"start_mode"`
+}
+
+type serviceTagsYml struct {
+	Match []string          `yaml:"match"`
+	Tags  map[string]string `yaml:"tags"`
+}
+
+type prometheusExportYml struct {
+	BindAddress string `yaml:"bind_address"`
+	BindPort    string `yaml:"bind_port"`
+	Path        string `yaml:"path"`
+}
+
+type sinkYml struct {
+	Type       string            `yaml:"type"`
+	Path       string            `yaml:"path"`
+	MaxSizeMB  int64             `yaml:"max_size_mb"`
+	MaxBackups int               `yaml:"max_backups"`
+	URL        string            `yaml:"url"`
+	Headers    map[string]string `yaml:"headers"`
+	Timeout    string            `yaml:"timeout"`
+	Retries    int               `yaml:"retries"`
+	RetryDelay string            `yaml:"retry_delay"`
+	Required   bool              `yaml:"required"`
+}
+
+type otlpYml struct {
+	Endpoint string            `yaml:"endpoint"`
+	Protocol string            `yaml:"protocol"`
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers"`
+	Timeout  string            `yaml:"timeout"`
 }
 
 // NewConfig reads the configuration from yml file
@@ -47,60 +182,528 @@ func NewConfig(filename string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open %s: %s", filename, err)
 	}
+	if err = checkUnknownKeys(yamlFile); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+	yamlFile, err = expandConfig(yamlFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
 	// Parse the file
-	c := configYml{
-		IncludeEntity: make(map[string][]string),
-		ExcludeEntity: make(map[string][]string),
+	var c configYml
+	if err := decodeStrict(yamlFile, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+
+	interval, err := parseScrapeInterval(c.ScrapeInterval, minScrapeInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+	log.Debug("running with scrape interval: %s", interval.String())
+
+	config := &Config{
+		ScrapeInterval:    interval,
+		ScrapeJitter:      c.ScrapeJitter,
+		MaxSkippedScrapes: c.MaxSkippedScrapes,
+		HeartBeatPeriod:   heartBeatPeriod,
+		EntityNameHost:    hostName,
+		droppedServices:   &droppedServices{},
+	}
+	if c.ScrapeJitter < 0 || c.ScrapeJitter > 1 {
+		return nil, fmt.Errorf("failed to parse config: scrape_jitter must be between 0 and 1")
+	}
+	if c.ScrapeAlign != "" {
+		if config.ScrapeAlign, err = time.ParseDuration(c.ScrapeAlign); err != nil || config.ScrapeAlign <= 0 {
+			return nil, fmt.Errorf("failed to parse config: invalid scrape_align: %s", c.ScrapeAlign)
+		}
+	}
+
+	switch c.MetricsSource {
+	case "":
+		config.MetricsSource = MetricsSourceExporter
+	case MetricsSourceExporter, MetricsSourceSCM, MetricsSourceFile:
+		config.MetricsSource = c.MetricsSource
+	default:
+		return nil, fmt.Errorf("failed to parse config: metrics_source must be %s, %s or %s", MetricsSourceExporter, MetricsSourceSCM, MetricsSourceFile)
 	}
-	if err := yaml.Unmarshal(yamlFile, &c); err != nil {
+	if config.MetricsSource == MetricsSourceFile && len(c.ReplayFiles) == 0 {
+		return nil, fmt.Errorf("failed to parse config: replay_files needs to be configured with the file metrics_source")
+	}
+	config.ReplayFiles = c.ReplayFiles
+
+	// the hostname identifies the instance in the outputs, e.g. the series of the Prometheus export
+	hostnames := make(map[string]int)
+	for idx, inst := range c.Instances {
+		instance, err := newInstanceConfig(inst, interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config: instances[%d]: %s", idx, err)
+		}
+		if prev, ok := hostnames[instance.Hostname]; ok {
+			return nil, fmt.Errorf("failed to parse config: instances[%d]: hostname %s is already used by instances[%d]", idx, instance.Hostname, prev)
+		}
+		hostnames[instance.Hostname] = idx
+		// jitter and alignment apply to every scrape loop
+		instance.ScrapeJitter = config.ScrapeJitter
+		instance.ScrapeAlign = config.ScrapeAlign
+		config.Instances = append(config.Instances, instance)
+	}
+
+	// The exporter is spawned unless only instances are configured
+	config.ScrapeLocal = len(c.Instances) == 0 || len(c.IncludeEntity) > 0 || len(c.ExcludeEntity) > 0
+	if config.ScrapeLocal {
+		if err = applyServiceConfig(c.serviceConfigYml, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	} else if set := setServiceOptions(c.serviceConfigYml); len(set) > 0 {
+		return nil, fmt.Errorf("failed to parse config: %s only apply to the local services, which are not scraped without include_matching_entities, configure them in the instances", strings.Join(set, ", "))
+	}
+
+	if c.ExporterBindAddress == "" {
+		c.ExporterBindAddress = defaultExporterBindAddress
+	}
+	if c.ExporterBindPort == "" {
+		c.ExporterBindPort = defaultExporterBindPort
+	}
+	// the free port is only selected when the exporter is started, see ExporterPort
+	if c.ExporterBindPort != autoPort {
+		if err = checkPort("exporter_bind_port", c.ExporterBindPort); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %s", err)
+		}
+	}
+	config.ExporterBindAddress = c.ExporterBindAddress
+	config.ExporterBindPort = c.ExporterBindPort
+
+	if config.OTLP, err = newOTLPConfig(c.OTLP); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %s", err)
 	}
 
-	var m matcher.Matcher
+	if config.Sinks, err = newSinkConfigs(c.Sinks); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+
+	if p := c.PrometheusExport; p != nil {
+		if p.BindPort == "" {
+			return nil, fmt.Errorf("failed to parse config: prometheus_export.bind_port needs to be configured")
+		}
+		if err = checkPort("prometheus_export.bind_port", p.BindPort); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %s", err)
+		}
+		if p.BindAddress == "" {
+			p.BindAddress = defaultPrometheusExportAddress
+		}
+		if p.Path == "" {
+			p.Path = defaultPrometheusExportPath
+		}
+		config.PrometheusExportAddress = net.JoinHostPort(p.BindAddress, p.BindPort)
+		config.PrometheusExportPath = p.Path
+	}
+	return config, nil
+}
+
+// setServiceOptions returns the keys of the service options set in s, other than the filters. They
+// only apply to the local services, so they are rejected when those are not scraped.
+func setServiceOptions(s serviceConfigYml) []string {
+	var set []string
+	if len(s.ServiceTags) > 0 {
+		set = append(set, "service_tags")
+	}
+	if len(s.DesiredState) > 0 {
+		set = append(set, "desired_state")
+	}
+	if s.MaxEntities != 0 {
+		set = append(set, "max_entities")
+	}
+	if s.EntitySelection != "" {
+		set = append(set, "entity_selection")
+	}
+	if len(s.OmitMetadata) > 0 {
+		set = append(set, "omit_metadata")
+	}
+	if len(s.CriticalServices) > 0 {
+		set = append(set, "critical_services")
+	}
+	return set
+}
+
+// applyServiceConfig sets the filters, tags and desired states of the config
+func applyServiceConfig(s serviceConfigYml, config *Config) error {
 	var includeFilters, excludeFilters []string
 
+	if err := checkFilterKeys("include_matching_entities", s.IncludeEntity); err != nil {
+		return err
+	}
+	if err := checkFilterKeys("exclude_matching_entities", s.ExcludeEntity); err != nil {
+		return err
+	}
+
 	// Get include filters
-	if val, ok := c.IncludeEntity["windowsService.name"]; ok {
+	if val, ok := s.IncludeEntity[serviceNameFilterKey]; ok {
 		includeFilters = val
 	}
 
 	// Get exclude filters
-	if val, ok := c.ExcludeEntity["windowsService.name"]; ok {
+	if val, ok := s.ExcludeEntity[serviceNameFilterKey]; ok {
 		excludeFilters = val
 	}
 
 	// Must have at least include filters (exclude-only is not supported)
 	if len(includeFilters) == 0 {
-		return nil, fmt.Errorf("failed to parse config: include_matching_entities is required for windowsService.name (exclude-only filtering is not supported)")
+		return fmt.Errorf("include_matching_entities is required for windowsService.name (exclude-only filtering is not supported)")
 	}
 
 	// Create matcher with both include and exclude filters
-	m = matcher.NewWithIncludesExcludes(includeFilters, excludeFilters)
-	if m.IsEmpty() {
-		return nil, fmt.Errorf("failed to parse config: no valid filter loaded")
+	var err error
+	config.Matcher, err = newMatcher(
+		"include_matching_entities."+serviceNameFilterKey, includeFilters,
+		"exclude_matching_entities."+serviceNameFilterKey, excludeFilters,
+		s.LenientFilters)
+	if err != nil {
+		return err
+	}
+	if config.Matcher.IsEmpty() {
+		return fmt.Errorf("no valid filter loaded")
 	}
 
-	if c.ExporterBindAddress == "" || c.ExporterBindPort == "" {
-		return nil, fmt.Errorf("exporter_bind_address and exporter_bind_port need to be configured")
+	if config.EntityLimit, err = newEntityLimit(s.MaxEntities, s.EntitySelection); err != nil {
+		return err
+	}
+	if config.OmitMetadata, err = newOmitMetadata(s.OmitMetadata); err != nil {
+		return err
+	}
+	if len(s.CriticalServices) > 0 {
+		m, err := newMatcher("critical_services", s.CriticalServices, "", nil, s.LenientFilters)
+		if err != nil {
+			return err
+		}
+		if m.IsEmpty() {
+			return fmt.Errorf("critical_services has no valid filter")
+		}
+		config.CriticalServices = &m
+	}
+	if config.ServiceTags, err = newServiceTags(s.ServiceTags, s.LenientFilters); err != nil {
+		return err
+	}
+	if config.DesiredStates, err = newDesiredStates(s.DesiredState, s.LenientFilters); err != nil {
+		return err
 	}
+	return nil
+}
+
+// newOmitMetadata checks that only the metadata that are not needed to identify the service are omitted
+func newOmitMetadata(metadata []string) ([]string, error) {
+	for idx, m := range metadata {
+		valid := false
+		for _, o := range omittableMetadata {
+			if m == o {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("omit_metadata[%d]: %s can't be omitted, valid values are %s", idx, m, strings.Join(omittableMetadata, ", "))
+		}
+	}
+	return metadata, nil
+}
 
-	interval, err := time.ParseDuration(c.ScrapeInterval)
+// newMatcher builds a matcher reporting the invalid filters with the key and the position they
+// have in the config. When lenient is set invalid filters are only logged and ignored.
+func newMatcher(includeKey string, includeFilters []string, excludeKey string, excludeFilters []string, lenient bool) (matcher.Matcher, error) {
+	m, err := matcher.NewWithIncludesExcludes(includeFilters, excludeFilters)
+	var invalid matcher.InvalidPatternsError
+	if !errors.As(err, &invalid) {
+		return m, err
+	}
+
+	// name the lists after their config keys so the error points at the filters to fix
+	keys := map[string]string{"include": includeKey, "exclude": excludeKey}
+	for i := range invalid {
+		invalid[i].List = keys[invalid[i].List]
+	}
+	if lenient {
+		log.Warn("ignoring %v", invalid)
+		return m, nil
+	}
+	return m, fmt.Errorf("failed to load filters: %w", invalid)
+}
+
+// newInstanceConfig creates the config of an instance scraping a remote exporter. The scrape
+// interval defaults to the top level one.
+func newInstanceConfig(inst instanceYml, defaultInterval time.Duration) (*Config, error) {
+	if inst.ExporterURL == "" {
+		return nil, fmt.Errorf("exporter_url needs to be configured")
+	}
+	if inst.Hostname == "" {
+		return nil, fmt.Errorf("hostname needs to be configured")
+	}
+	interval, err := parseScrapeInterval(inst.ScrapeInterval, defaultInterval)
 	if err != nil {
-		log.Error("error parsing scrape interval:%s", err.Error())
-		interval = minScrapeInterval
+		return nil, err
+	}
+
+	config := &Config{
+		ExporterURL:     inst.ExporterURL,
+		Hostname:        inst.Hostname,
+		EntityNameHost:  inst.EntityNameHost,
+		ScrapeInterval:  interval,
+		droppedServices: &droppedServices{},
+	}
+	// localhost would be replaced by the Agent with the name of the host running the integration
+	if config.EntityNameHost == "" {
+		config.EntityNameHost = inst.Hostname
+	}
+	if err = applyServiceConfig(inst.serviceConfigYml, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseScrapeInterval returns defaultInterval when the interval is empty. Intervals shorter than 15s are raised to it.
+func parseScrapeInterval(value string, defaultInterval time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scrape_interval: %s", err)
 	}
 	if interval < minScrapeInterval {
 		log.Warn("scrap interval defined is less than 15s. Interval has set to 15s ")
 		interval = minScrapeInterval
 	}
-	log.Debug("running with scrape interval: %s", interval.String())
+	return interval, nil
+}
 
-	config := &Config{
-		Matcher:             m,
-		ExporterBindAddress: c.ExporterBindAddress,
-		ExporterBindPort:    c.ExporterBindPort,
-		ScrapeInterval:      interval,
-		HeartBeatPeriod:     heartBeatPeriod,
+func newOTLPConfig(c *otlpYml) (*otlp.Config, error) {
+	if c == nil {
+		return nil, nil
 	}
-	return config, nil
+	if c.Endpoint == "" {
+		return nil, fmt.Errorf("otlp endpoint needs to be configured")
+	}
+
+	protocol := c.Protocol
+	if protocol == "" {
+		protocol = otlp.ProtocolHTTP
+	}
+	if protocol != otlp.ProtocolHTTP && protocol != otlp.ProtocolGRPC {
+		return nil, fmt.Errorf("otlp protocol must be %s or %s", otlp.ProtocolHTTP, otlp.ProtocolGRPC)
+	}
+
+	timeout := defaultOTLPTimeout
+	if c.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, fmt.Errorf("error parsing otlp timeout: %s", err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("otlp timeout must be positive")
+		}
+	}
+
+	return &otlp.Config{
+		Endpoint: c.Endpoint,
+		Protocol: protocol,
+		Insecure: c.Insecure,
+		Headers:  c.Headers,
+		Timeout:  timeout,
+	}, nil
+}
+
+// newSinkConfigs returns the configured sinks, when none is configured the payload is
+// written only to stdout. The heartbeats are always written to stdout, so the configured sinks
+// must include it, otherwise the Agent would keep the integration alive without getting any data.
+func newSinkConfigs(sinks []sinkYml) ([]sink.Config, error) {
+	if len(sinks) == 0 {
+		return []sink.Config{{Type: sink.TypeStdout}}, nil
+	}
+
+	var configs []sink.Config
+	stdout := false
+	for idx, s := range sinks {
+		c := sink.Config{
+			Type:       s.Type,
+			Path:       s.Path,
+			MaxSize:    s.MaxSizeMB * bytesPerMB,
+			MaxBackups: s.MaxBackups,
+			URL:        s.URL,
+			Headers:    s.Headers,
+			Timeout:    defaultSinkTimeout,
+			Policy: sink.Policy{
+				Retries:  s.Retries,
+				Required: s.Required,
+			},
+		}
+		var err error
+		if s.Timeout != "" {
+			if c.Timeout, err = time.ParseDuration(s.Timeout); err != nil {
+				return nil, fmt.Errorf("error parsing timeout of sink %d: %s", idx, err)
+			}
+			if c.Timeout <= 0 {
+				return nil, fmt.Errorf("timeout of sink %d must be positive", idx)
+			}
+		}
+		if s.RetryDelay != "" {
+			if c.Policy.RetryDelay, err = time.ParseDuration(s.RetryDelay); err != nil {
+				return nil, fmt.Errorf("error parsing retry_delay of sink %d: %s", idx, err)
+			}
+			if c.Policy.RetryDelay < 0 {
+				return nil, fmt.Errorf("retry_delay of sink %d cannot be negative", idx)
+			}
+		}
+		if err = c.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid sink %d: %s", idx, err)
+		}
+		if c.Type == sink.TypeStdout {
+			stdout = true
+		}
+		configs = append(configs, c)
+	}
+	if !stdout {
+		return nil, fmt.Errorf("sinks must include a %s sink, the Agent reads the payloads and the heartbeats from it", sink.TypeStdout)
+	}
+	return configs, nil
+}
+
+func newEntityLimit(max int, selection string) (EntityLimit, error) {
+	if max < 0 {
+		return EntityLimit{}, fmt.Errorf("max_entities cannot be negative")
+	}
+	if selection == "" {
+		selection = SelectionAlphabetical
+	}
+	if !validSelection(selection) {
+		return EntityLimit{}, fmt.Errorf("entity_selection must be %s, %s or %s", SelectionAlphabetical, SelectionAutoStartFirst, SelectionRunningFirst)
+	}
+	return EntityLimit{Max: max, Selection: selection}, nil
+}
+
+func newServiceTags(rules []serviceTagsYml, lenient bool) (ServiceTags, error) {
+	var serviceTags ServiceTags
+	for idx, r := range rules {
+		if len(r.Tags) == 0 {
+			return nil, fmt.Errorf("service_tags rule %d has no tags", idx)
+		}
+		m, err := newMatcher(fmt.Sprintf("service_tags[%d].match", idx), r.Match, "", nil, lenient)
+		if err != nil {
+			return nil, err
+		}
+		if m.IsEmpty() {
+			return nil, fmt.Errorf("service_tags rule %d has no valid match filter", idx)
+		}
+		serviceTags = append(serviceTags, TagRule{Matcher: m, Tags: r.Tags})
+	}
+	return serviceTags, nil
+}
+
+func newDesiredStates(rules []desiredStateYml, lenient bool) (DesiredStates, error) {
+	var desiredStates DesiredStates
+	for idx, r := range rules {
+		if r.State == "" && r.StartMode == "" {
+			return nil, fmt.Errorf("desired_state rule %d needs state or start_mode", idx)
+		}
+		m, err := newMatcher(fmt.Sprintf("desired_state[%d].match", idx), r.Match, "", nil, lenient)
+		if err != nil {
+			return nil, err
+		}
+		if m.IsEmpty() {
+			return nil, fmt.Errorf("desired_state rule %d has no valid match filter", idx)
+		}
+		rule := DesiredStateRule{
+			Matcher:   m,
+			State:     strings.ToLower(r.State),
+			StartMode: strings.ToLower(r.StartMode),
+		}
+		if rule.State != "" && !contains(serviceStates, rule.State) {
+			return nil, fmt.Errorf("desired_state rule %d has an unknown state %q, valid values are %s", idx, r.State, strings.Join(serviceStates, ", "))
+		}
+		if rule.StartMode != "" && !contains(serviceStartModes, rule.StartMode) {
+			return nil, fmt.Errorf("desired_state rule %d has an unknown start_mode %q, valid values are %s", idx, r.StartMode, strings.Join(serviceStartModes, ", "))
+		}
+		desiredStates = append(desiredStates, rule)
+	}
+	return desiredStates, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUnknownKeys decodes the config in strict mode to report, with their line, the keys
+// that are not supported, e.g. typos. Other errors are reported when the config is parsed.
+func checkUnknownKeys(content []byte) error {
+	var c configYml
+	var typeErr *yaml.TypeError
+	if err := decodeStrict(content, &c); !errors.As(err, &typeErr) {
+		return nil
+	}
+
+	var unknown []string
+	for _, e := range typeErr.Errors {
+		if s := unknownKeyError.FindStringSubmatch(e); s != nil {
+			unknown = append(unknown, fmt.Sprintf("line %s: %s", s[1], s[2]))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// decodeStrict decodes the config failing on the keys not defined in configYml, an empty
+// config is valid
+func decodeStrict(content []byte, c *configYml) error {
+	d := yaml.NewDecoder(bytes.NewReader(content))
+	d.KnownFields(true)
+	if err := d.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func checkFilterKeys(section string, filters map[string][]string) error {
+	for k := range filters {
+		if k != serviceNameFilterKey {
+			return fmt.Errorf("%s only supports %s, found: %s", section, serviceNameFilterKey, k)
+		}
+	}
+	return nil
+}
+
+func checkPort(key, port string) error {
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("%s must be a port number between 1 and 65535, found: %s", key, port)
+	}
+	return nil
+}
+
+// ExporterPort returns the port the exporter listens on, when exporter_bind_port is auto a free
+// port is selected on every call
+func (c *Config) ExporterPort() (string, error) {
+	if c.ExporterBindPort != autoPort {
+		return c.ExporterBindPort, nil
+	}
+	port, err := freePort(c.ExporterBindAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to select exporter_bind_port: %s", err)
+	}
+	log.Debug("exporter_bind_port selected automatically: %s", port)
+	return port, nil
+}
+
+// freePort returns a port that is free on the address when it is called. It could be taken by
+// another process before the exporter binds it, which would make the exporter fail.
+func freePort(address string) (string, error) {
+	lis, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		return "", err
+	}
+	defer lis.Close()
+	_, port, err := net.SplitHostPort(lis.Addr().String())
+	return port, err
 }
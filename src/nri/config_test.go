@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,10 +6,16 @@
 package nri
 
 import (
+	"errors"
 	"io/ioutil"
+	"net"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/newrelic/nri-winservices/src/matcher"
+	"github.com/newrelic/nri-winservices/src/sink"
 	"github.com/stretchr/testify/require"
 )
 
@@ -121,3 +124,933 @@ exclude_matching_entities:
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "include_matching_entities is required")
 }
+
+func TestNewConfigOTLP(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+otlp:
+  endpoint: localhost:4317
+  protocol: grpc
+  insecure: true
+  headers:
+    api-key: secret`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.NotNil(t, config.OTLP)
+	require.Equal(t, "localhost:4317", config.OTLP.Endpoint)
+	require.Equal(t, "grpc", config.OTLP.Protocol)
+	require.True(t, config.OTLP.Insecure)
+	require.Equal(t, "secret", config.OTLP.Headers["api-key"])
+	require.Equal(t, defaultOTLPTimeout, config.OTLP.Timeout)
+}
+
+func TestNewConfigOTLPInvalidProtocol(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+otlp:
+  endpoint: localhost:4317
+  protocol: udp`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "otlp protocol")
+}
+
+func TestNewConfigSinks(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: stdout
+    required: true
+  - type: file
+    path: payloads.jsonl
+    max_size_mb: 10
+    max_backups: 3
+  - type: http
+    url: http://localhost:8080/payloads
+    timeout: 5s
+    retries: 2
+    retry_delay: 1s`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Len(t, config.Sinks, 3)
+	require.True(t, config.Sinks[0].Policy.Required)
+	require.Equal(t, int64(10*bytesPerMB), config.Sinks[1].MaxSize)
+	require.Equal(t, 3, config.Sinks[1].MaxBackups)
+	require.Equal(t, 5*time.Second, config.Sinks[2].Timeout)
+	require.Equal(t, 2, config.Sinks[2].Policy.Retries)
+	require.Equal(t, time.Second, config.Sinks[2].Policy.RetryDelay)
+}
+
+func TestNewConfigDefaultSink(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, []sink.Config{{Type: sink.TypeStdout}}, config.Sinks)
+}
+
+func TestNewConfigPrometheusExport(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+prometheus_export:
+  bind_port: 9183`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:9183", config.PrometheusExportAddress)
+	require.Equal(t, "/metrics", config.PrometheusExportPath)
+}
+
+func TestNewConfigPrometheusExportIPv6(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+prometheus_export:
+  bind_address: "::1"
+  bind_port: 9183`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "[::1]:9183", config.PrometheusExportAddress)
+}
+
+func TestNewConfigServiceTags(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+service_tags:
+  - match:
+      - regex "^MSSQL.*"
+      - "SQLSERVERAGENT"
+    tags:
+      team: dba
+      tier: "1"
+  - match:
+      - "MSSQLSERVER"
+    tags:
+      runbook: https://runbooks/mssql`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Len(t, config.ServiceTags, 2)
+	require.Equal(t, map[string]string{"team": "dba", "tier": "1", "runbook": "https://runbooks/mssql"}, config.ServiceTags.tagsFor("MSSQLSERVER"))
+	require.Equal(t, map[string]string{"team": "dba", "tier": "1"}, config.ServiceTags.tagsFor("sqlserveragent"))
+}
+
+func TestNewConfigDesiredState(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+desired_state:
+  - match:
+      - "RpcSs"
+    state: Running
+    start_mode: auto
+  - match:
+      - "RemoteRegistry"
+    start_mode: disabled`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Len(t, config.DesiredStates, 2)
+	require.Equal(t, "running", config.DesiredStates[0].State)
+	require.Equal(t, "disabled", config.DesiredStates[1].StartMode)
+}
+
+func TestNewConfigDesiredStateWithoutExpectation(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+desired_state:
+  - match:
+      - "RpcSs"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "needs state or start_mode")
+}
+
+func TestNewConfigUnknownKeys(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+scrape_intervall: 30s
+include_matching_entity:
+  windowsService.name:
+    - regex ".*"
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+otlp:
+  endpoint: localhost:4317
+  protocl: grpc`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown keys: line 4: scrape_intervall, line 5: include_matching_entity, line 13: protocl")
+}
+
+func TestNewConfigDuplicateKeys(t *testing.T) {
+	content := []byte(`
+scrape_interval: 30s
+scrape_interval: 60s
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `line 3: mapping key "scrape_interval" already defined at line 2`)
+}
+
+func TestNewConfigInvalidValues(t *testing.T) {
+	tests := map[string]struct {
+		content     string
+		expectedErr string
+	}{
+		"invalid scrape_interval": {
+			content: `
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+scrape_interval: 30
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "invalid scrape_interval",
+		},
+		"invalid exporter port": {
+			content: `
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 91820
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "exporter_bind_port must be a port number",
+		},
+		"invalid prometheus_export port": {
+			content: `
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+prometheus_export:
+  bind_port: http`,
+			expectedErr: "prometheus_export.bind_port must be a port number",
+		},
+		"missing prometheus_export port": {
+			content: `
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+prometheus_export:
+  path: /metrics`,
+			expectedErr: "failed to parse config: prometheus_export.bind_port needs to be configured",
+		},
+		"unsupported filter key": {
+			content: `
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: 9182
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+exclude_matching_entities:
+  windowsService.displayName:
+    - "Themes"`,
+			expectedErr: "exclude_matching_entities only supports windowsService.name",
+		},
+		"scrape_jitter out of range": {
+			content: `
+scrape_jitter: 1.5
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "scrape_jitter must be between 0 and 1",
+		},
+		"omit_metadata required field": {
+			content: `
+omit_metadata:
+  - image_path
+  - service_name
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "omit_metadata[1]: service_name can't be omitted",
+		},
+		"invalid critical_services": {
+			content: `
+critical_services:
+  - regex "[a"
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: `critical_services[0] "regex \"[a\""`,
+		},
+		"unknown metrics_source": {
+			content: `
+metrics_source: wmi
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "metrics_source must be exporter, scm or file",
+		},
+		"file metrics_source without replay_files": {
+			content: `
+metrics_source: file
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "replay_files needs to be configured with the file metrics_source",
+		},
+		"invalid scrape_align": {
+			content: `
+scrape_align: minute
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "invalid scrape_align: minute",
+		},
+		"negative max_entities": {
+			content: `
+max_entities: -1
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "max_entities cannot be negative",
+		},
+		"unknown entity_selection": {
+			content: `
+max_entities: 10
+entity_selection: random
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`,
+			expectedErr: "entity_selection must be alphabetical, auto_start_first or running_first",
+		},
+		"invalid exclude filter": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+exclude_matching_entities:
+  windowsService.name:
+    - "Themes"
+    - regex "^(Spooler"`,
+			expectedErr: `exclude_matching_entities.windowsService.name[1] "regex \"^(Spooler\""`,
+		},
+		"invalid service_tags filter": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+service_tags:
+  - match:
+      - "spooler"
+    tags:
+      team: printing
+  - match:
+      - glob "sql[*"
+    tags:
+      team: dba`,
+			expectedErr: `service_tags[1].match[0] "glob \"sql[*\""`,
+		},
+		"sinks without stdout": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: file
+    path: payloads.jsonl`,
+			expectedErr: "sinks must include a stdout sink, the Agent reads the payloads and the heartbeats from it",
+		},
+		"stdout sink with retries": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: stdout
+    retries: 2`,
+			expectedErr: "invalid sink 0: retries are not supported for stdout sink",
+		},
+		"zero otlp timeout": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+otlp:
+  endpoint: http://localhost:4318/v1/metrics
+  timeout: 0s`,
+			expectedErr: "otlp timeout must be positive",
+		},
+		"zero sink timeout": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: stdout
+  - type: http
+    url: https://example.com/payloads
+    timeout: 0s`,
+			expectedErr: "timeout of sink 1 must be positive",
+		},
+		"negative sink retry_delay": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: stdout
+  - type: http
+    url: https://example.com/payloads
+    retry_delay: -1s`,
+			expectedErr: "retry_delay of sink 1 cannot be negative",
+		},
+		"sink with negative retries": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+sinks:
+  - type: stdout
+    required: true
+    retries: -1`,
+			expectedErr: "invalid sink 0: retries cannot be negative",
+		},
+		"service options without local scraping": {
+			content: `
+service_tags:
+  - match:
+      - "spooler"
+    tags:
+      team: printing
+max_entities: 10
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: sql-01
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`,
+			expectedErr: "service_tags, max_entities only apply to the local services, which are not scraped without include_matching_entities",
+		},
+		"unknown desired state": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+desired_state:
+  - match:
+      - "RpcSs"
+    start_mode: auto
+  - match:
+      - "Spooler"
+    state: started`,
+			expectedErr: `desired_state rule 1 has an unknown state "started", valid values are continue pending, pause pending, paused, running`,
+		},
+		"unknown desired start_mode": {
+			content: `
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+desired_state:
+  - match:
+      - "RpcSs"
+    start_mode: automatic`,
+			expectedErr: `desired_state rule 0 has an unknown start_mode "automatic", valid values are auto, boot, disabled, manual, system`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpfile, err := ioutil.TempFile("", "config")
+			require.NoError(t, err)
+			defer os.Remove(tmpfile.Name()) // clean up
+			_, err = tmpfile.Write([]byte(tt.content))
+			require.NoError(t, err)
+
+			_, err = NewConfig(tmpfile.Name())
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestNewConfigExporterDefaults(t *testing.T) {
+	content := []byte(`
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", config.ExporterBindAddress)
+	// a free port is selected when the exporter is started, 9182 may be taken by a standalone exporter
+	require.Equal(t, autoPort, config.ExporterBindPort)
+	require.Equal(t, minScrapeInterval, config.ScrapeInterval)
+	require.Equal(t, MetricsSourceExporter, config.MetricsSource)
+}
+
+func TestNewConfigOmitMetadata(t *testing.T) {
+	content := []byte(`
+omit_metadata:
+  - image_path
+  - description
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: remote
+    omit_metadata:
+      - sid_type
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"image_path", "description"}, config.OmitMetadata)
+	require.Len(t, config.Instances, 1)
+	require.Equal(t, []string{"sid_type"}, config.Instances[0].OmitMetadata)
+}
+
+func TestNewConfigCriticalServices(t *testing.T) {
+	content := []byte(`
+critical_services:
+  - prefix "MSSQL"
+  - "spooler"
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: remote
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.NotNil(t, config.CriticalServices)
+	require.True(t, config.CriticalServices.Match("MSSQLSERVER"))
+	require.True(t, config.CriticalServices.Match("Spooler"))
+	require.False(t, config.CriticalServices.Match("themes"))
+	// instances default to the services starting automatically
+	require.Len(t, config.Instances, 1)
+	require.Nil(t, config.Instances[0].CriticalServices)
+}
+
+func TestNewConfigMetricsSourceSCM(t *testing.T) {
+	content := []byte(`
+metrics_source: scm
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, MetricsSourceSCM, config.MetricsSource)
+}
+
+func TestNewConfigMetricsSourceFile(t *testing.T) {
+	content := []byte(`
+metrics_source: file
+replay_files:
+  - C:\dumps\first.prom
+  - C:\dumps\second.prom
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, MetricsSourceFile, config.MetricsSource)
+	require.Equal(t, []string{`C:\dumps\first.prom`, `C:\dumps\second.prom`}, config.ReplayFiles)
+}
+
+func TestNewConfigExporterAutoPort(t *testing.T) {
+	content := []byte(`
+exporter_bind_port: auto
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", config.ExporterBindAddress)
+	require.Equal(t, autoPort, config.ExporterBindPort)
+
+	// the selected port is free
+	port, err := config.ExporterPort()
+	require.NoError(t, err)
+	require.NoError(t, checkPort("exporter_bind_port", port))
+	lis, err := net.Listen("tcp", net.JoinHostPort(config.ExporterBindAddress, port))
+	require.NoError(t, err)
+	lis.Close()
+}
+
+func TestExporterPortConfigured(t *testing.T) {
+	config := &Config{ExporterBindAddress: "127.0.0.1", ExporterBindPort: "9182"}
+	port, err := config.ExporterPort()
+	require.NoError(t, err)
+	require.Equal(t, "9182", port)
+}
+
+func TestNewConfigInstances(t *testing.T) {
+	content := []byte(`
+scrape_interval: 30s
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: sql-01
+    scrape_interval: 60s
+    include_matching_entities:
+      windowsService.name:
+        - regex "^MSSQL.*"
+    service_tags:
+      - match:
+          - regex ".*"
+        tags:
+          team: dba
+  - exporter_url: http://10.0.0.6:9182/metrics
+    hostname: web-01
+    entity_name_host: web-01.example.com
+    include_matching_entities:
+      windowsService.name:
+        - "W3SVC"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.False(t, config.ScrapeLocal, "the exporter is not needed when only instances are configured")
+	require.Len(t, config.Instances, 2)
+
+	sql := config.Instances[0]
+	require.Equal(t, "http://10.0.0.5:9182/metrics", sql.ExporterURL)
+	require.Equal(t, "sql-01", sql.Hostname)
+	require.Equal(t, "sql-01", sql.EntityNameHost)
+	require.Equal(t, 60*time.Second, sql.ScrapeInterval)
+	require.True(t, sql.Matcher.Match("MSSQLSERVER"))
+	require.False(t, sql.Matcher.Match("W3SVC"))
+	require.Equal(t, map[string]string{"team": "dba"}, sql.ServiceTags.tagsFor("MSSQLSERVER"))
+
+	web := config.Instances[1]
+	require.Equal(t, "web-01.example.com", web.EntityNameHost)
+	require.Equal(t, 30*time.Second, web.ScrapeInterval)
+	require.True(t, web.Matcher.Match("w3svc"))
+}
+
+func TestNewConfigInstancesAndLocal(t *testing.T) {
+	content := []byte(`
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instances[0]: hostname needs to be configured")
+
+	content = []byte(strings.Replace(string(content), "  - exporter_url", "  - hostname: sql-01\n    exporter_url", 1))
+	require.NoError(t, os.WriteFile(tmpfile.Name(), content, 0o600))
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.True(t, config.ScrapeLocal)
+	require.Equal(t, "localhost", config.EntityNameHost)
+	require.Len(t, config.Instances, 1)
+}
+
+func TestNewConfigDuplicateInstanceHostname(t *testing.T) {
+	content := []byte(`
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: sql-01
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"
+  - exporter_url: http://10.0.0.6:9182/metrics
+    hostname: sql-01
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instances[1]: hostname sql-01 is already used by instances[0]")
+}
+
+func TestNewConfigInvalidFiltersListsAll(t *testing.T) {
+	content := []byte(`
+include_matching_entities:
+  windowsService.name:
+    - regex "[a"
+    - "spooler"
+    - regex "(b"
+exclude_matching_entities:
+  windowsService.name:
+    - regex "*c"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "include_matching_entities.windowsService.name[0]")
+	require.Contains(t, err.Error(), "include_matching_entities.windowsService.name[2]")
+	require.Contains(t, err.Error(), "exclude_matching_entities.windowsService.name[0]")
+
+	var invalid matcher.InvalidPatternsError
+	require.True(t, errors.As(err, &invalid))
+	require.Len(t, invalid, 3)
+}
+
+func TestNewConfigLenientFilters(t *testing.T) {
+	content := []byte(`
+lenient_filters: true
+include_matching_entities:
+  windowsService.name:
+    - regex "[a"
+    - "spooler"
+exclude_matching_entities:
+  windowsService.name:
+    - regex "*c"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	c, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.True(t, c.Matcher.Match("Spooler"))
+	require.False(t, c.Matcher.Match("themes"))
+}
+
+func TestNewConfigEntityLimit(t *testing.T) {
+	content := []byte(`
+max_entities: 50
+entity_selection: running_first
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: remote
+    max_entities: 20
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	c, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, EntityLimit{Max: 50, Selection: SelectionRunningFirst}, c.EntityLimit)
+	require.Len(t, c.Instances, 1)
+	require.Equal(t, EntityLimit{Max: 20, Selection: SelectionAlphabetical}, c.Instances[0].EntityLimit)
+}
+
+func TestNewConfigScrapeJitterAndAlign(t *testing.T) {
+	content := []byte(`
+scrape_interval: 60s
+scrape_jitter: 0.25
+scrape_align: 1m
+max_skipped_scrapes: 5
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+instances:
+  - exporter_url: http://10.0.0.5:9182/metrics
+    hostname: remote
+    include_matching_entities:
+      windowsService.name:
+        - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	c, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, 0.25, c.ScrapeJitter)
+	require.Equal(t, time.Minute, c.ScrapeAlign)
+	require.Len(t, c.Instances, 1)
+	require.Equal(t, 0.25, c.Instances[0].ScrapeJitter)
+	require.Equal(t, time.Minute, c.Instances[0].ScrapeAlign)
+	// only the scrapes of the local services stop the integration
+	require.Equal(t, uint64(5), c.MaxSkippedScrapes)
+	require.Zero(t, c.Instances[0].MaxSkippedScrapes)
+}
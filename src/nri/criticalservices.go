@@ -0,0 +1,45 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"strconv"
+
+	"github.com/newrelic/nri-winservices/src/matcher"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// criticalWithoutRestartMetadata flags the critical services that are not restarted when they fail
+const criticalWithoutRestartMetadata = "critical_without_restart"
+
+// addFailureActions adds the failure actions and the reset period of the services to their entity
+// metadata. The critical services are flagged when none of their failure actions restarts them.
+// When criticalServices is nil the services starting automatically are considered critical.
+func addFailureActions(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, ebn entitiesByName, criticalServices *matcher.Matcher) {
+	resetPeriods := familyMetrics(metricFamilyMap, failureResetPeriodMetric)
+	if len(resetPeriods) == 0 {
+		return
+	}
+	configs := parseFailureConfigs(entityRules.EntityName.Label, resetPeriods, familyMetrics(metricFamilyMap, failureActionMetric))
+
+	isCritical := func(serviceName string) bool { return criticalServices.Match(serviceName) }
+	if criticalServices == nil {
+		startModes := enumValues(metricFamilyMap, entityRules, serviceStartModeMetric, startModeLabel, ebn, nil)
+		isCritical = func(serviceName string) bool { return startModes[serviceName] == autoStartMode }
+	}
+
+	for serviceName, e := range ebn {
+		config, ok := configs[serviceName]
+		if !ok {
+			continue
+		}
+		warnOnErr(e.AddMetadata(failureActionsMetadata, config.actionsValue()))
+		warnOnErr(e.AddMetadata(failureResetPeriodMetadata, config.resetPeriodValue()))
+		if isCritical(serviceName) {
+			warnOnErr(e.AddMetadata(criticalWithoutRestartMetadata, strconv.FormatBool(!config.hasRestart())))
+		}
+	}
+}
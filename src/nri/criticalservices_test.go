@@ -0,0 +1,90 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failureActionsFixture adds failure actions to the summary fixture: rpcss and spooler start
+// automatically, only rpcss is restarted on failure.
+func failureActionsFixture() scraper.MetricFamiliesByName {
+	mfbn := summaryFixture()
+	mfbn[failureResetPeriodMetric] = &dto.MetricFamily{
+		Name: strPtr(failureResetPeriodMetric),
+		Type: &gauge,
+		Metric: []*dto.Metric{
+			labeledGauge(86400, "name", "rpcss"),
+			labeledGauge(0, "name", "spooler"),
+			labeledGauge(3600, "name", "themes"),
+		},
+	}
+	mfbn[failureActionMetric] = &dto.MetricFamily{
+		Name: strPtr(failureActionMetric),
+		Type: &gauge,
+		Metric: []*dto.Metric{
+			labeledGauge(1, "action", "restart", "delay_ms", "60000", "index", "0", "name", "rpcss"),
+			labeledGauge(1, "action", "reboot", "delay_ms", "0", "index", "1", "name", "rpcss"),
+			labeledGauge(1, "action", "run_command", "delay_ms", "0", "index", "0", "name", "themes"),
+		},
+	}
+	return mfbn
+}
+
+func processFailureActions(t *testing.T, config *Config) map[string]map[string]interface{} {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	require.NoError(t, ProcessMetrics(i, failureActionsFixture(), config, hostname))
+
+	metadata := make(map[string]map[string]interface{})
+	for _, e := range i.Entities {
+		metadata[e.GetMetadata()["service_name"].(string)] = e.GetMetadata()
+	}
+	return metadata
+}
+
+func TestProcessMetricsAddsFailureActions(t *testing.T) {
+	metadata := processFailureActions(t, &Config{Matcher: mustMatcher([]string{"rpcss", "spooler", "themes"})})
+
+	assert.Equal(t, "restart:1m0s,reboot:0s", metadata["rpcss"][failureActionsMetadata])
+	assert.Equal(t, "24h0m0s", metadata["rpcss"][failureResetPeriodMetadata])
+	assert.Equal(t, "none", metadata["spooler"][failureActionsMetadata])
+	assert.Equal(t, "0s", metadata["spooler"][failureResetPeriodMetadata])
+	assert.Equal(t, "run_command:0s", metadata["themes"][failureActionsMetadata])
+
+	// without critical_services the services starting automatically are critical
+	assert.Equal(t, "false", metadata["rpcss"][criticalWithoutRestartMetadata])
+	assert.Equal(t, "true", metadata["spooler"][criticalWithoutRestartMetadata])
+	assert.Nil(t, metadata["themes"][criticalWithoutRestartMetadata])
+}
+
+func TestProcessMetricsCriticalServices(t *testing.T) {
+	critical := mustMatcher([]string{"themes"})
+	metadata := processFailureActions(t, &Config{
+		Matcher:          mustMatcher([]string{"rpcss", "spooler", "themes"}),
+		CriticalServices: &critical,
+	})
+
+	assert.Nil(t, metadata["rpcss"][criticalWithoutRestartMetadata])
+	assert.Nil(t, metadata["spooler"][criticalWithoutRestartMetadata])
+	assert.Equal(t, "true", metadata["themes"][criticalWithoutRestartMetadata])
+}
+
+func TestProcessMetricsWithoutFailureActions(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	require.NoError(t, ProcessMetrics(i, summaryFixture(), &Config{Matcher: mustMatcher([]string{"spooler"})}, hostname))
+
+	require.Len(t, i.Entities, 1)
+	metadata := i.Entities[0].GetMetadata()
+	// the exporter doesn't report failure actions, so nothing is flagged
+	assert.Nil(t, metadata[failureActionsMetadata])
+	assert.Nil(t, metadata[criticalWithoutRestartMetadata])
+}
@@ -0,0 +1,97 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+const (
+	// serviceDependencyMetric is only reported by the scm metrics source, the exporter doesn't collect dependencies
+	serviceDependencyMetric = "windows_service_dependency"
+	dependencyLabel         = "dependency"
+	dependsOnMetadata       = "depends_on"
+	requiredByMetadata      = "required_by"
+	// dependencySeparator joins the service names in the metadata values
+	dependencySeparator = ","
+)
+
+// serviceDependency is an edge of the graph, Service can't run without Dependency
+type serviceDependency struct {
+	Service    string
+	Dependency string
+}
+
+// dependencyGraph holds the direct dependencies between all the services of the host, so the
+// dependents of a service are known even when they are not matched by the filters. Service names
+// are lowercase, as the SCM compares them case insensitively.
+type dependencyGraph struct {
+	dependsOn  map[string][]string
+	requiredBy map[string][]string
+}
+
+// newDependencyGraph builds the graph from its edges. Duplicated edges and services depending on
+// themselves are ignored, the lists of each service are sorted.
+func newDependencyGraph(dependencies []serviceDependency) dependencyGraph {
+	g := dependencyGraph{
+		dependsOn:  make(map[string][]string),
+		requiredBy: make(map[string][]string),
+	}
+	seen := make(map[serviceDependency]struct{}, len(dependencies))
+	for _, d := range dependencies {
+		edge := serviceDependency{Service: strings.ToLower(d.Service), Dependency: strings.ToLower(d.Dependency)}
+		if edge.Service == "" || edge.Dependency == "" || edge.Service == edge.Dependency {
+			continue
+		}
+		if _, ok := seen[edge]; ok {
+			continue
+		}
+		seen[edge] = struct{}{}
+		g.dependsOn[edge.Service] = append(g.dependsOn[edge.Service], edge.Dependency)
+		g.requiredBy[edge.Dependency] = append(g.requiredBy[edge.Dependency], edge.Service)
+	}
+	for _, services := range g.dependsOn {
+		sort.Strings(services)
+	}
+	for _, services := range g.requiredBy {
+		sort.Strings(services)
+	}
+	return g
+}
+
+// dependenciesFrom reads the edges of the graph from the dependency metric
+func dependenciesFrom(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules) []serviceDependency {
+	var dependencies []serviceDependency
+	for _, m := range familyMetrics(metricFamilyMap, serviceDependencyMetric) {
+		serviceName, err := getLabelValue(m.GetLabel(), entityRules.EntityName.Label)
+		if err != nil {
+			continue
+		}
+		dependency, err := getLabelValue(m.GetLabel(), dependencyLabel)
+		if err != nil {
+			continue
+		}
+		dependencies = append(dependencies, serviceDependency{Service: serviceName, Dependency: dependency})
+	}
+	return dependencies
+}
+
+// addDependencies adds to the entities the services they depend on and the services depending on
+// them as metadata. Services without dependencies or dependents don't get the metadata.
+func addDependencies(ebn entitiesByName, graph dependencyGraph) {
+	for serviceName, e := range ebn {
+		key := strings.ToLower(serviceName)
+		if dependsOn := graph.dependsOn[key]; len(dependsOn) > 0 {
+			warnOnErr(e.AddMetadata(dependsOnMetadata, strings.Join(dependsOn, dependencySeparator)))
+		}
+		if requiredBy := graph.requiredBy[key]; len(requiredBy) > 0 {
+			warnOnErr(e.AddMetadata(requiredByMetadata, strings.Join(requiredBy, dependencySeparator)))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDependencyGraph(t *testing.T) {
+	g := newDependencyGraph([]serviceDependency{
+		{Service: "LanmanWorkstation", Dependency: "NSI"},
+		{Service: "lanmanworkstation", Dependency: "bowser"},
+		{Service: "nsi", Dependency: "RpcSs"},
+		{Service: "dhcp", Dependency: "nsi"},
+		{Service: "dhcp", Dependency: "NSI"},
+		// ignored edges
+		{Service: "loop", Dependency: "Loop"},
+		{Service: "", Dependency: "rpcss"},
+	})
+
+	assert.Equal(t, map[string][]string{
+		"lanmanworkstation": {"bowser", "nsi"},
+		"nsi":               {"rpcss"},
+		"dhcp":              {"nsi"},
+	}, g.dependsOn)
+	assert.Equal(t, map[string][]string{
+		"nsi":    {"dhcp", "lanmanworkstation"},
+		"bowser": {"lanmanworkstation"},
+		"rpcss":  {"nsi"},
+	}, g.requiredBy)
+}
+
+func TestNewDependencyGraphCycle(t *testing.T) {
+	g := newDependencyGraph([]serviceDependency{
+		{Service: "a", Dependency: "b"},
+		{Service: "b", Dependency: "a"},
+	})
+	assert.Equal(t, []string{"b"}, g.dependsOn["a"])
+	assert.Equal(t, []string{"b"}, g.requiredBy["a"])
+}
+
+func dependencyFixture() *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: strPtr(serviceDependencyMetric),
+		Type: &gauge,
+		Metric: []*dto.Metric{
+			labeledGauge(1, "name", "spooler", "dependency", "RPCSS"),
+			labeledGauge(1, "name", "spooler", "dependency", "http"),
+			labeledGauge(1, "name", "themes", "dependency", "rpcss"),
+			labeledGauge(1, "name", "notmatched", "dependency", "spooler"),
+			// metrics without the labels are skipped
+			labeledGauge(1, "name", "themes"),
+		},
+	}
+}
+
+func TestDependenciesFrom(t *testing.T) {
+	mfbn := summaryFixture()
+	assert.Empty(t, dependenciesFrom(mfbn, loadRules()))
+
+	mfbn[serviceDependencyMetric] = dependencyFixture()
+	assert.Equal(t, []serviceDependency{
+		{Service: "spooler", Dependency: "RPCSS"},
+		{Service: "spooler", Dependency: "http"},
+		{Service: "themes", Dependency: "rpcss"},
+		{Service: "notmatched", Dependency: "spooler"},
+	}, dependenciesFrom(mfbn, loadRules()))
+}
+
+func TestProcessMetricsAddsDependencies(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	mfbn := summaryFixture()
+	mfbn[serviceDependencyMetric] = dependencyFixture()
+	config := &Config{Matcher: mustMatcher([]string{"rpcss", "spooler", "themes"})}
+
+	require.NoError(t, ProcessMetrics(i, mfbn, config, hostname))
+
+	entities := make(map[string]*integration.Entity)
+	for _, e := range i.Entities {
+		entities[e.GetMetadata()["service_name"].(string)] = e
+	}
+	require.Len(t, entities, 3)
+
+	// dependents are reported even when they are not matched by the filters
+	assert.Nil(t, entities["rpcss"].GetMetadata()[dependsOnMetadata])
+	assert.Equal(t, "spooler,themes", entities["rpcss"].GetMetadata()[requiredByMetadata])
+	assert.Equal(t, "http,rpcss", entities["spooler"].GetMetadata()[dependsOnMetadata])
+	assert.Equal(t, "notmatched", entities["spooler"].GetMetadata()[requiredByMetadata])
+	assert.Equal(t, "rpcss", entities["themes"].GetMetadata()[dependsOnMetadata])
+	assert.Nil(t, entities["themes"].GetMetadata()[requiredByMetadata])
+}
@@ -0,0 +1,100 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/data/event"
+	"github.com/newrelic/nri-winservices/src/matcher"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+const (
+	desiredStateCompliantMetadata = "desired_state_compliant"
+	desiredStateEventCategory     = "desired_state"
+)
+
+// The states and start modes reported by the exporter and the scm metrics source, the only values
+// a desired state can be compared with
+var (
+	serviceStates     = []string{"continue pending", "pause pending", "paused", "running", "start pending", "stop pending", "stopped"}
+	serviceStartModes = []string{"auto", "boot", "disabled", "manual", "system"}
+)
+
+// DesiredStateRule defines the expected state and/or start mode of the services matching the Matcher.
+// An empty State or StartMode is not checked.
+type DesiredStateRule struct {
+	Matcher   matcher.Matcher
+	State     string
+	StartMode string
+}
+
+// DesiredStates holds the desired state rules in the order they are configured
+type DesiredStates []DesiredStateRule
+
+// expectedFor merges the rules matching the service, later rules override the fields set by earlier ones.
+// ok is false when no rule matches the service.
+func (ds DesiredStates) expectedFor(serviceName string) (state, startMode string, ok bool) {
+	for _, rule := range ds {
+		if !rule.Matcher.Match(serviceName) {
+			continue
+		}
+		ok = true
+		if rule.State != "" {
+			state = rule.State
+		}
+		if rule.StartMode != "" {
+			startMode = rule.StartMode
+		}
+	}
+	return state, startMode, ok
+}
+
+// checkDesiredStates compares the actual state and start mode of the services having a desired state.
+// The result is added as metadata to the entity, and every violation is reported with an event.
+func checkDesiredStates(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, ebn entitiesByName, desiredStates DesiredStates) {
+	if len(desiredStates) == 0 {
+		return
+	}
+	states := enumValues(metricFamilyMap, entityRules, serviceStateMetric, stateLabel, ebn, nil)
+	startModes := enumValues(metricFamilyMap, entityRules, serviceStartModeMetric, startModeLabel, ebn, nil)
+
+	now := time.Now()
+	for serviceName, e := range ebn {
+		expectedState, expectedStartMode, ok := desiredStates.expectedFor(serviceName)
+		if !ok {
+			continue
+		}
+		actualState, actualStartMode := states[serviceName], startModes[serviceName]
+		compliant := (expectedState == "" || expectedState == actualState) &&
+			(expectedStartMode == "" || expectedStartMode == actualStartMode)
+		warnOnErr(e.AddMetadata(desiredStateCompliantMetadata, strconv.FormatBool(compliant)))
+		if compliant {
+			continue
+		}
+
+		summary := fmt.Sprintf("Service %s is not in the desired state", serviceName)
+		ev, err := event.New(now, summary, desiredStateEventCategory)
+		if err != nil {
+			warnOnErr(err)
+			continue
+		}
+		attributes := map[string]string{
+			"service_name":        serviceName,
+			"expected_state":      expectedState,
+			"actual_state":        actualState,
+			"expected_start_mode": expectedStartMode,
+			"actual_start_mode":   actualStartMode,
+		}
+		for k, v := range attributes {
+			warnOnErr(ev.AddAttribute(k, v))
+		}
+		e.AddEvent(ev)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDesiredStates = DesiredStates{
+	{
+		Matcher:   mustMatcher([]string{`regex "^(rpcss|spooler)$"`}),
+		State:     "running",
+		StartMode: "auto",
+	},
+	{
+		Matcher:   mustMatcher([]string{"themes"}),
+		StartMode: "disabled",
+	},
+	{
+		Matcher: mustMatcher([]string{"spooler"}),
+		State:   "stopped",
+	},
+}
+
+func TestExpectedForMergesRulesInOrder(t *testing.T) {
+	state, startMode, ok := testDesiredStates.expectedFor("spooler")
+	assert.True(t, ok)
+	assert.Equal(t, "stopped", state)
+	assert.Equal(t, "auto", startMode)
+
+	state, startMode, ok = testDesiredStates.expectedFor("Themes")
+	assert.True(t, ok)
+	assert.Empty(t, state)
+	assert.Equal(t, "disabled", startMode)
+
+	_, _, ok = testDesiredStates.expectedFor("notmatched")
+	assert.False(t, ok)
+}
+
+func TestProcessMetricsChecksDesiredStates(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher: mustMatcher([]string{`regex ".*"`}),
+		DesiredStates: DesiredStates{
+			{Matcher: mustMatcher([]string{"rpcss", "spooler"}), State: "running", StartMode: "auto"},
+			{Matcher: mustMatcher([]string{"themes"}), StartMode: "disabled"},
+		},
+	}
+
+	require.NoError(t, ProcessMetrics(i, summaryFixture(), config, hostname))
+
+	entities := make(map[string]*integration.Entity)
+	for _, e := range i.Entities {
+		entities[e.GetMetadata()["service_name"].(string)] = e
+	}
+	require.Len(t, entities, 4)
+
+	assert.Equal(t, "true", entities["rpcss"].GetMetadata()[desiredStateCompliantMetadata])
+	assert.Empty(t, entities["rpcss"].Events)
+
+	assert.Equal(t, "true", entities["themes"].GetMetadata()[desiredStateCompliantMetadata])
+	assert.Empty(t, entities["themes"].Events)
+
+	assert.Nil(t, entities["notmatched"].GetMetadata()[desiredStateCompliantMetadata])
+	assert.Empty(t, entities["notmatched"].Events)
+
+	spooler := entities["spooler"]
+	assert.Equal(t, "false", spooler.GetMetadata()[desiredStateCompliantMetadata])
+	require.Len(t, spooler.Events, 1)
+	ev := spooler.Events[0]
+	assert.Equal(t, desiredStateEventCategory, ev.Category)
+	assert.Equal(t, "Service spooler is not in the desired state", ev.Summary)
+	assert.Equal(t, map[string]interface{}{
+		"service_name":        "spooler",
+		"expected_state":      "running",
+		"actual_state":        "stopped",
+		"expected_start_mode": "auto",
+		"actual_start_mode":   "auto",
+	}, ev.Attributes)
+}
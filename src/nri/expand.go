@@ -0,0 +1,240 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	filePrefix     = "file:"
+	defaultMarker  = ":-"
+	escapedDollars = "$${"
+)
+
+// placeholder matches the escaped form $${ and ${...} placeholders
+var placeholder = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// expandConfig replaces the placeholders found in the values of the yaml document:
+//
+//	${ENV_VAR}            value of the environment variable, it must be defined
+//	${ENV_VAR:-default}   value of the environment variable or default when not defined or empty
+//	${file:path}          content of the file without the trailing new line
+//	$${                   literal ${
+//
+// The values are replaced in the text of the document keeping their quoting, so they are typed
+// by the decoder as if they had been written in the file and the line numbers of the decoding
+// errors still refer to it. Only values written in a single line can hold placeholders.
+func expandConfig(content []byte) ([]byte, error) {
+	if !strings.Contains(string(content), "${") {
+		return content, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	e := expander{lines: strings.SplitAfter(string(content), "\n")}
+	if err := e.expandNode(&doc, ""); err != nil {
+		return nil, err
+	}
+	return []byte(e.apply()), nil
+}
+
+// expander collects the replacements of the scalars holding placeholders
+type expander struct {
+	lines []string
+	edits []scalarEdit
+}
+
+// scalarEdit replaces the text of a scalar, line and column are 1-based as reported by the parser
+type scalarEdit struct {
+	line, column, length int
+	text                 string
+}
+
+func (e *expander) expandNode(node *yaml.Node, key string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := e.expandNode(child, key); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// keys are never expanded
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			childKey := node.Content[idx].Value
+			if key != "" {
+				childKey = key + "." + childKey
+			}
+			if err := e.expandNode(node.Content[idx+1], childKey); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for idx, child := range node.Content {
+			if err := e.expandNode(child, fmt.Sprintf("%s[%d]", key, idx)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return e.expandScalar(node, key)
+	}
+	return nil
+}
+
+func (e *expander) expandScalar(node *yaml.Node, key string) error {
+	if !strings.Contains(node.Value, "${") {
+		return nil
+	}
+	expanded, err := expandValue(node.Value, key)
+	if err != nil {
+		return err
+	}
+
+	length, ok := e.scalarLength(node)
+	if !ok {
+		return fmt.Errorf("placeholders are only supported in single line values, found in %s", key)
+	}
+	e.edits = append(e.edits, scalarEdit{
+		line:   node.Line,
+		column: node.Column,
+		length: length,
+		text:   quoteScalar(expanded, node.Style),
+	})
+	return nil
+}
+
+// scalarLength returns the length in characters of the scalar as written in the document, it's
+// false when the scalar doesn't end in the line where it starts.
+func (e *expander) scalarLength(node *yaml.Node) (int, bool) {
+	if node.Line < 1 || node.Line > len(e.lines) {
+		return 0, false
+	}
+	line := []rune(e.lines[node.Line-1])
+	if node.Column < 1 || node.Column > len(line) {
+		return 0, false
+	}
+	text := line[node.Column-1:]
+
+	switch node.Style {
+	case 0:
+		value := []rune(node.Value)
+		if len(text) < len(value) || string(text[:len(value)]) != node.Value {
+			return 0, false
+		}
+		return len(value), true
+	case yaml.DoubleQuotedStyle:
+		for idx := 1; idx < len(text); idx++ {
+			switch text[idx] {
+			case '\\':
+				idx++
+			case '"':
+				return idx + 1, true
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for idx := 1; idx < len(text); idx++ {
+			if text[idx] != '\'' {
+				continue
+			}
+			if idx+1 < len(text) && text[idx+1] == '\'' {
+				idx++
+				continue
+			}
+			return idx + 1, true
+		}
+	}
+	return 0, false
+}
+
+// quoteScalar writes the value with the quoting of the original scalar. A plain value is double
+// quoted when it would otherwise be read differently, e.g. when it contains ": ".
+func quoteScalar(value string, style yaml.Style) string {
+	switch style {
+	case 0:
+		if value != "" && !strings.ContainsAny(value, ",[]{}") {
+			var doc yaml.Node
+			if yaml.Unmarshal([]byte("key: "+value), &doc) == nil && len(doc.Content) == 1 {
+				if m := doc.Content[0]; len(m.Content) == 2 && m.Content[1].Kind == yaml.ScalarNode &&
+					m.Content[1].Style == 0 && m.Content[1].Value == value {
+					return value
+				}
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		if !strings.Contains(value, "\n") {
+			return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+		}
+	}
+	return strconv.Quote(value)
+}
+
+// apply replaces the scalars from the end of the document, so the columns of the pending edits
+// are not shifted.
+func (e *expander) apply() string {
+	sort.Slice(e.edits, func(i, j int) bool {
+		if e.edits[i].line != e.edits[j].line {
+			return e.edits[i].line > e.edits[j].line
+		}
+		return e.edits[i].column > e.edits[j].column
+	})
+	for _, edit := range e.edits {
+		line := []rune(e.lines[edit.line-1])
+		start := edit.column - 1
+		e.lines[edit.line-1] = string(line[:start]) + edit.text + string(line[start+edit.length:])
+	}
+	return strings.Join(e.lines, "")
+}
+
+// expandValue replaces the placeholders of a value, the result is always a string
+func expandValue(value, key string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var err error
+	expanded := placeholder.ReplaceAllStringFunc(value, func(match string) string {
+		if match == escapedDollars || err != nil {
+			return "${"
+		}
+		var resolved string
+		resolved, err = resolve(match[2:len(match)-1], key)
+		return resolved
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+func resolve(expr, key string) (string, error) {
+	if strings.HasPrefix(expr, filePrefix) {
+		path := strings.TrimPrefix(expr, filePrefix)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file %s referenced in %s: %v", path, key, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	name, def, hasDefault := strings.Cut(expr, defaultMarker)
+	if value, ok := os.LookupEnv(name); ok && (value != "" || !hasDefault) {
+		return value, nil
+	}
+	if hasDefault {
+		return def, nil
+	}
+	return "", fmt.Errorf("unresolved variable %s in %s", name, key)
+}
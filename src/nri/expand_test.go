@@ -0,0 +1,198 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandValue(t *testing.T) {
+	t.Setenv("WINSERVICES_ADDRESS", "10.0.0.1")
+	t.Setenv("WINSERVICES_EMPTY", "")
+	t.Setenv("WINSERVICES_INSECURE", "true")
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cr3t\r\n"), 0o600))
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"no placeholders", "no placeholders"},
+		{"${WINSERVICES_ADDRESS}", "10.0.0.1"},
+		{"http://${WINSERVICES_ADDRESS}:${WINSERVICES_PORT:-4318}/v1", "http://10.0.0.1:4318/v1"},
+		{"${WINSERVICES_EMPTY:-default}", "default"},
+		{"${WINSERVICES_EMPTY}", ""},
+		{"${WINSERVICES_INSECURE}", "true"},
+		{"${WINSERVICES_PORT:-9182}", "9182"},
+		{"port ${WINSERVICES_PORT:-9182}", "port 9182"},
+		{"${file:" + secret + "}", "s3cr3t"},
+		{`regex "^Themes$"`, `regex "^Themes$"`},
+		{"$${WINSERVICES_ADDRESS}", "${WINSERVICES_ADDRESS}"},
+	}
+	for _, tt := range tests {
+		actual, err := expandValue(tt.value, "key")
+		require.NoError(t, err, tt.value)
+		require.Equal(t, tt.expected, actual, tt.value)
+	}
+}
+
+func TestExpandValueErrors(t *testing.T) {
+	_, err := expandValue("${WINSERVICES_UNDEFINED}", "otlp.headers.api-key")
+	require.EqualError(t, err, "unresolved variable WINSERVICES_UNDEFINED in otlp.headers.api-key")
+
+	_, err = expandValue("${file:/does/not/exist}", "otlp.headers.api-key")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "referenced in otlp.headers.api-key")
+}
+
+func TestNewConfigExpandsVariables(t *testing.T) {
+	t.Setenv("WINSERVICES_PORT", "9999")
+	t.Setenv("WINSERVICES_SERVICE", "newrelic-infra")
+	content := []byte(`
+exporter_bind_address: ${WINSERVICES_ADDRESS:-127.0.0.1}
+exporter_bind_port: ${WINSERVICES_PORT}
+scrape_interval: ${WINSERVICES_INTERVAL:-30s}
+include_matching_entities:
+  windowsService.name:
+    - "${WINSERVICES_SERVICE}"
+service_tags:
+  - match:
+      - "${WINSERVICES_SERVICE}"
+    tags:
+      team: ${WINSERVICES_TEAM:-platform}`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", config.ExporterBindAddress)
+	require.Equal(t, "9999", config.ExporterBindPort)
+	require.True(t, config.Matcher.Match("newrelic-infra"))
+	require.Equal(t, map[string]string{"team": "platform"}, config.ServiceTags.tagsFor("newrelic-infra"))
+}
+
+func TestNewConfigUnresolvedVariable(t *testing.T) {
+	content := []byte(`
+exporter_bind_address: 127.0.0.1
+exporter_bind_port: ${WINSERVICES_UNDEFINED}
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unresolved variable WINSERVICES_UNDEFINED in exporter_bind_port")
+}
+
+func TestExpandConfig(t *testing.T) {
+	t.Setenv("WINSERVICES_SERVICE", "yes")
+	t.Setenv("WINSERVICES_HEX", "0x1F")
+	t.Setenv("WINSERVICES_JITTER", "0.2")
+	t.Setenv("WINSERVICES_TEAM", "a: b #c")
+	t.Setenv("WINSERVICES_QUOTE", `it's "quoted"`)
+
+	content := `# ${WINSERVICES_UNDEFINED} in a comment is left as it is
+scrape_jitter: ${WINSERVICES_JITTER}
+include_matching_entities:
+  windowsService.name:
+    - "${WINSERVICES_SERVICE}"
+    - '${WINSERVICES_QUOTE}'
+    - ${WINSERVICES_HEX}
+tags:
+  team: ${WINSERVICES_TEAM}
+  hex: "${WINSERVICES_HEX}"
+  literal: "$${WINSERVICES_HEX} ${WINSERVICES_HEX}"
+`
+	expected := `# ${WINSERVICES_UNDEFINED} in a comment is left as it is
+scrape_jitter: 0.2
+include_matching_entities:
+  windowsService.name:
+    - "yes"
+    - 'it''s "quoted"'
+    - 0x1F
+tags:
+  team: "a: b #c"
+  hex: "0x1F"
+  literal: "${WINSERVICES_HEX} 0x1F"
+`
+	actual, err := expandConfig([]byte(content))
+	require.NoError(t, err)
+	require.Equal(t, expected, string(actual))
+}
+
+func TestExpandConfigMultiLineValue(t *testing.T) {
+	t.Setenv("WINSERVICES_TEAM", "platform")
+	_, err := expandConfig([]byte("service_tags:\n  - tags:\n      team: >\n        ${WINSERVICES_TEAM}\n"))
+	require.EqualError(t, err, "placeholders are only supported in single line values, found in service_tags[0].tags.team")
+}
+
+func TestNewConfigExpandKeepsQuotedStrings(t *testing.T) {
+	t.Setenv("WINSERVICES_SERVICE", "yes")
+	t.Setenv("WINSERVICES_HEX", "0x1F")
+	t.Setenv("WINSERVICES_JITTER", "0.2")
+	t.Setenv("WINSERVICES_MAX", "10")
+	content := []byte(`
+scrape_jitter: ${WINSERVICES_JITTER}
+max_entities: ${WINSERVICES_MAX}
+include_matching_entities:
+  windowsService.name:
+    - "${WINSERVICES_SERVICE}"
+service_tags:
+  - match:
+      - "${WINSERVICES_SERVICE}"
+    tags:
+      build: "${WINSERVICES_HEX}"`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	config, err := NewConfig(tmpfile.Name())
+	require.NoError(t, err)
+	require.Equal(t, 0.2, config.ScrapeJitter)
+	require.Equal(t, 10, config.EntityLimit.Max)
+	require.True(t, config.Matcher.Match("yes"))
+	require.False(t, config.Matcher.Match("true"))
+	require.Equal(t, map[string]string{"build": "0x1F"}, config.ServiceTags.tagsFor("yes"))
+}
+
+func TestNewConfigExpandKeepsLineNumbers(t *testing.T) {
+	t.Setenv("WINSERVICES_ADDRESS", "10.0.0.1")
+	content := []byte(`exporter_bind_address: ${WINSERVICES_ADDRESS}
+
+include_matching_entities:
+  windowsService.name:
+    - regex ".*"
+max_entities: many`)
+
+	tmpfile, err := ioutil.TempFile("", "config")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name()) // clean up
+	_, err = tmpfile.Write(content)
+	require.NoError(t, err)
+
+	_, err = NewConfig(tmpfile.Name())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 6: cannot unmarshal")
+}
@@ -0,0 +1,156 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// the failure actions are only reported by the scm metrics source
+	failureActionMetric      = "windows_service_failure_action"
+	failureResetPeriodMetric = "windows_service_failure_reset_period_seconds"
+
+	failureActionsMetadata     = "failure_actions"
+	failureResetPeriodMetadata = "failure_reset_period"
+
+	restartAction = "restart"
+	// noFailureActions is reported for the services whose failures are not handled
+	noFailureActions = "none"
+	// infiniteReset is reported when the failure count is never reset
+	infiniteReset = "infinite"
+)
+
+// failureAction is an action taken by the SCM when the service fails, after Delay
+type failureAction struct {
+	Action string
+	Delay  time.Duration
+}
+
+// failureConfig holds what the SCM does when a service fails. Actions are sorted by the failure they
+// apply to, the last one is repeated for the later failures. The failure count is reset after
+// ResetPeriod without failures, unless InfiniteReset is set.
+type failureConfig struct {
+	Actions       []failureAction
+	ResetPeriod   time.Duration
+	InfiniteReset bool
+}
+
+// hasRestart returns true when the service is restarted on some failure
+func (f failureConfig) hasRestart() bool {
+	for _, a := range f.Actions {
+		if a.Action == restartAction {
+			return true
+		}
+	}
+	return false
+}
+
+// actionsValue formats the actions as action:delay, e.g. restart:1m0s,reboot:0s
+func (f failureConfig) actionsValue() string {
+	if len(f.Actions) == 0 {
+		return noFailureActions
+	}
+	actions := make([]string, len(f.Actions))
+	for i, a := range f.Actions {
+		actions[i] = a.Action + ":" + a.Delay.String()
+	}
+	return strings.Join(actions, ",")
+}
+
+func (f failureConfig) resetPeriodValue() string {
+	if f.InfiniteReset {
+		return infiniteReset
+	}
+	return f.ResetPeriod.String()
+}
+
+// parseFailureConfigs reads the failure config of each service, whose name is in the nameLabel label,
+// from the reset period and the failure action metrics. The reset period is reported for every
+// service, so the services without it are left out since their actions are unknown. Metrics with
+// missing or invalid labels are skipped.
+func parseFailureConfigs(nameLabel string, resetPeriods, actions []*dto.Metric) map[string]*failureConfig {
+	configs := make(map[string]*failureConfig)
+	for _, m := range resetPeriods {
+		serviceName, err := getLabelValue(m.GetLabel(), nameLabel)
+		if err != nil {
+			continue
+		}
+		seconds := m.GetGauge().GetValue()
+		switch {
+		case math.IsInf(seconds, 1):
+			configs[serviceName] = &failureConfig{InfiniteReset: true}
+		case seconds >= 0:
+			configs[serviceName] = &failureConfig{ResetPeriod: time.Duration(seconds) * time.Second}
+		}
+	}
+
+	indexes := make(map[string][]int)
+	for _, m := range actions {
+		serviceName, err := getLabelValue(m.GetLabel(), nameLabel)
+		if err != nil {
+			continue
+		}
+		config, ok := configs[serviceName]
+		if !ok {
+			continue
+		}
+		action, index, delay, ok := parseFailureAction(m.GetLabel())
+		if !ok {
+			continue
+		}
+		config.Actions = append(config.Actions, failureAction{Action: action, Delay: delay})
+		indexes[serviceName] = append(indexes[serviceName], index)
+	}
+
+	// the actions are sorted by index since the metrics order is not guaranteed
+	for serviceName, idx := range indexes {
+		sort.Stable(byIndex{actions: configs[serviceName].Actions, indexes: idx})
+	}
+	return configs
+}
+
+func parseFailureAction(labels []*dto.LabelPair) (action string, index int, delay time.Duration, ok bool) {
+	action, err := getLabelValue(labels, "action")
+	if err != nil || action == "" {
+		return "", 0, 0, false
+	}
+	indexValue, err := getLabelValue(labels, "index")
+	if err != nil {
+		return "", 0, 0, false
+	}
+	if index, err = strconv.Atoi(indexValue); err != nil || index < 0 {
+		return "", 0, 0, false
+	}
+	delayValue, err := getLabelValue(labels, "delay_ms")
+	if err != nil {
+		return "", 0, 0, false
+	}
+	ms, err := strconv.ParseInt(delayValue, 10, 64)
+	if err != nil || ms < 0 {
+		return "", 0, 0, false
+	}
+	return action, index, time.Duration(ms) * time.Millisecond, true
+}
+
+// byIndex sorts the actions of a service by the index they have been reported with
+type byIndex struct {
+	actions []failureAction
+	indexes []int
+}
+
+func (b byIndex) Len() int           { return len(b.actions) }
+func (b byIndex) Less(i, j int) bool { return b.indexes[i] < b.indexes[j] }
+func (b byIndex) Swap(i, j int) {
+	b.actions[i], b.actions[j] = b.actions[j], b.actions[i]
+	b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i]
+}
@@ -0,0 +1,104 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func failureActionMetrics() (resetPeriods, actions []*dto.Metric) {
+	resetPeriods = []*dto.Metric{
+		labeledGauge(86400, "name", "spooler"),
+		labeledGauge(math.Inf(1), "name", "eventlog"),
+		labeledGauge(0, "name", "themes"),
+		// invalid reset periods are skipped
+		labeledGauge(-1, "name", "negative"),
+		labeledGauge(60),
+	}
+	actions = []*dto.Metric{
+		// reported out of order
+		labeledGauge(1, "action", "none", "delay_ms", "0", "index", "2", "name", "spooler"),
+		labeledGauge(1, "action", "restart", "delay_ms", "60000", "index", "0", "name", "spooler"),
+		labeledGauge(1, "action", "restart", "delay_ms", "120000", "index", "1", "name", "spooler"),
+		labeledGauge(1, "action", "reboot", "delay_ms", "1500", "index", "0", "name", "eventlog"),
+		// services without reset period and invalid actions are skipped
+		labeledGauge(1, "action", "restart", "delay_ms", "0", "index", "0", "name", "unknown"),
+		labeledGauge(1, "action", "restart", "delay_ms", "-5", "index", "1", "name", "eventlog"),
+		labeledGauge(1, "action", "restart", "delay_ms", "0", "index", "x", "name", "eventlog"),
+		labeledGauge(1, "action", "restart", "index", "1", "name", "eventlog"),
+		labeledGauge(1, "action", "", "delay_ms", "0", "index", "1", "name", "eventlog"),
+	}
+	return resetPeriods, actions
+}
+
+func TestParseFailureConfigs(t *testing.T) {
+	resetPeriods, actions := failureActionMetrics()
+	configs := parseFailureConfigs("name", resetPeriods, actions)
+
+	assert.Equal(t, map[string]*failureConfig{
+		"spooler": {
+			ResetPeriod: 24 * time.Hour,
+			Actions: []failureAction{
+				{Action: "restart", Delay: time.Minute},
+				{Action: "restart", Delay: 2 * time.Minute},
+				{Action: "none"},
+			},
+		},
+		"eventlog": {
+			InfiniteReset: true,
+			Actions:       []failureAction{{Action: "reboot", Delay: 1500 * time.Millisecond}},
+		},
+		"themes": {},
+	}, configs)
+}
+
+func TestParseFailureConfigsEmpty(t *testing.T) {
+	assert.Empty(t, parseFailureConfigs("name", nil, nil))
+}
+
+func TestFailureConfigValues(t *testing.T) {
+	tests := map[string]struct {
+		config      failureConfig
+		actions     string
+		resetPeriod string
+		hasRestart  bool
+	}{
+		"restart": {
+			config: failureConfig{
+				ResetPeriod: 24 * time.Hour,
+				Actions:     []failureAction{{Action: "restart", Delay: time.Minute}, {Action: "none"}},
+			},
+			actions:     "restart:1m0s,none:0s",
+			resetPeriod: "24h0m0s",
+			hasRestart:  true,
+		},
+		"reboot only": {
+			config: failureConfig{
+				InfiniteReset: true,
+				Actions:       []failureAction{{Action: "reboot", Delay: 1500 * time.Millisecond}, {Action: "run_command"}},
+			},
+			actions:     "reboot:1.5s,run_command:0s",
+			resetPeriod: "infinite",
+		},
+		"no actions": {
+			config:      failureConfig{},
+			actions:     "none",
+			resetPeriod: "0s",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.actions, tt.config.actionsValue())
+			assert.Equal(t, tt.resetPeriod, tt.config.resetPeriodValue())
+			assert.Equal(t, tt.hasRestart, tt.config.hasRestart())
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzProcessMetrics feeds mutated exporter outputs through the scraper parsing and ProcessMetrics,
+// which must not panic whatever the exporter answers.
+func FuzzProcessMetrics(f *testing.F) {
+	for _, dump := range []string{"testdata/dumps/services.prom", "../scraper/testdata/actualOutput"} {
+		content, err := ioutil.ReadFile(dump)
+		require.NoError(f, err)
+		f.Add(dumpExcerpt(content, "spooler", "wuauserv", "lsm"))
+	}
+	f.Add([]byte(exportertest.Metrics(
+		exportertest.Service{Name: "spooler", DisplayName: "Print Spooler", ProcessID: 1234},
+		exportertest.Service{Name: "themes", State: "stopped", StartMode: "disabled"},
+	)))
+	f.Add([]byte("windows_service_info{name=\"spooler\"} 1\n"))
+
+	config := &Config{
+		Matcher:       mustMatcher([]string{`regex ".*"`}),
+		ServiceTags:   ServiceTags{{Matcher: mustMatcher([]string{"spooler"}), Tags: map[string]string{"team": "printing"}}},
+		DesiredStates: DesiredStates{{Matcher: mustMatcher([]string{"spooler"}), State: "running"}},
+		EntityLimit:   EntityLimit{Max: 100, Selection: SelectionRunningFirst},
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		metricFamilyMap, err := scraper.Parse(bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+
+		i, err := integration.New("com.newrelic.winservices", "v0.0.0", integration.Writer(ioutil.Discard))
+		require.NoError(t, err)
+		if err = ProcessMetrics(i, metricFamilyMap, config, hostname); err != nil {
+			return
+		}
+		require.NoError(t, i.Publish())
+	})
+}
+
+// dumpExcerpt keeps the comments and the samples of the given services, since big inputs slow down fuzzing
+func dumpExcerpt(content []byte, services ...string) []byte {
+	var excerpt bytes.Buffer
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		keep := bytes.HasPrefix(line, []byte("#"))
+		for _, s := range services {
+			keep = keep || bytes.Contains(line, []byte(`name="`+s+`"`))
+		}
+		if keep {
+			excerpt.Write(line)
+		}
+	}
+	return excerpt.Bytes()
+}
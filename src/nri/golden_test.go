@@ -0,0 +1,118 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/newrelic/nri-winservices/test/jsonschema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update rewrites the golden files with the current output: go test ./src/nri -run TestGolden -update
+var update = flag.Bool("update", false, "update the golden files")
+
+const (
+	goldenDir      = "testdata/golden"
+	goldenHostname = "golden-host"
+	schemaPath     = "../../test/json-schema-files/winservices-schema.json"
+)
+
+// goldenCases feed an exporter dump through the scraper parsing and ProcessMetrics using the config
+// testdata/golden/<name>.yml, and compare the payload with testdata/golden/<name>.json.
+//
+// testdata/dumps/services.prom is not a dump of the bundled exporter. It holds the windows_service
+// families of ../scraper/testdata/actualOutput, dumped by an older exporter, converted to the labels
+// of the bundled one: service_name renamed to name and the non-zero process_id moved from
+// windows_service_info to windows_service_process. Replace it with a dump of the bundled exporter
+// when one is available.
+var goldenCases = []struct {
+	name string
+	dump string
+	// schema validates the payload against the schema used by the integration tests, which expects
+	// the entities of a whole host.
+	schema bool
+}{
+	{name: "all_services", dump: "testdata/dumps/services.prom", schema: true},
+	{name: "filtered", dump: "testdata/dumps/services.prom"},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := goldenPayload(t, tc.dump, filepath.Join(goldenDir, tc.name+".yml"))
+
+			if tc.schema {
+				require.NoError(t, jsonschema.Validate(schemaPath, string(payload)))
+			}
+
+			actual := normalizePayload(t, payload)
+			goldenFile := filepath.Join(goldenDir, tc.name+".json")
+			if *update {
+				require.NoError(t, ioutil.WriteFile(goldenFile, actual, 0644))
+			}
+			expected, err := ioutil.ReadFile(goldenFile)
+			require.NoError(t, err, "run the test with -update to create the golden file")
+			assert.Equal(t, string(expected), string(actual))
+		})
+	}
+}
+
+// goldenPayload returns the payload published for the given dump and config
+func goldenPayload(t *testing.T, dump, configPath string) []byte {
+	f, err := os.Open(dump)
+	require.NoError(t, err)
+	defer f.Close()
+	metricFamilyMap, err := scraper.Parse(f)
+	require.NoError(t, err)
+
+	config, err := NewConfig(configPath)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	i, err := integration.New("com.newrelic.winservices", "v0.0.0", integration.Writer(&buf))
+	require.NoError(t, err)
+
+	require.NoError(t, ProcessMetrics(i, metricFamilyMap, config, goldenHostname))
+	require.NoError(t, i.Publish())
+	return buf.Bytes()
+}
+
+// normalizePayload indents the payload and removes the timestamps, which change on every run
+func normalizePayload(t *testing.T, payload []byte) []byte {
+	var p interface{}
+	require.NoError(t, json.Unmarshal(payload, &p))
+	removeTimestamps(p)
+	out, err := json.MarshalIndent(p, "", "  ")
+	require.NoError(t, err)
+	return append(out, '\n')
+}
+
+func removeTimestamps(v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, child := range value {
+			if k == "timestamp" {
+				value[k] = 0
+				continue
+			}
+			removeTimestamps(child)
+		}
+	case []interface{}:
+		for _, child := range value {
+			removeTimestamps(child)
+		}
+	}
+}
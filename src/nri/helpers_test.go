@@ -0,0 +1,65 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"github.com/newrelic/nri-winservices/src/scraper"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// labeledGauge creates a gauge metric, labels are name and value pairs
+func labeledGauge(value float64, labels ...string) *dto.Metric {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: float64Ptr(value)}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: strPtr(labels[i]), Value: strPtr(labels[i+1])})
+	}
+	return m
+}
+
+// summaryFixture has the families reported for every service: rpcss is running, spooler starts
+// automatically but is stopped, themes is disabled and notmatched is usually left out by the filters.
+func summaryFixture() scraper.MetricFamiliesByName {
+	return scraper.MetricFamiliesByName{
+		"windows_service_info": &dto.MetricFamily{
+			Name: strPtr("windows_service_info"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "rpcss", "display_name", "RPC"),
+				labeledGauge(1, "name", "spooler", "display_name", "Print Spooler"),
+				labeledGauge(1, "name", "themes", "display_name", "Themes"),
+				labeledGauge(1, "name", "notmatched", "display_name", "Not Matched"),
+			},
+		},
+		"windows_service_state": &dto.MetricFamily{
+			Name: strPtr("windows_service_state"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "rpcss", "state", "running"),
+				labeledGauge(0, "name", "rpcss", "state", "stopped"),
+				labeledGauge(0, "name", "spooler", "state", "running"),
+				labeledGauge(1, "name", "spooler", "state", "stopped"),
+				labeledGauge(0, "name", "themes", "state", "running"),
+				labeledGauge(1, "name", "themes", "state", "stopped"),
+				labeledGauge(0, "name", "notmatched", "state", "running"),
+				labeledGauge(1, "name", "notmatched", "state", "stopped"),
+			},
+		},
+		"windows_service_start_mode": &dto.MetricFamily{
+			Name: strPtr("windows_service_start_mode"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "rpcss", "start_mode", "auto"),
+				labeledGauge(0, "name", "rpcss", "start_mode", "disabled"),
+				labeledGauge(1, "name", "spooler", "start_mode", "auto"),
+				labeledGauge(0, "name", "spooler", "start_mode", "disabled"),
+				labeledGauge(0, "name", "themes", "start_mode", "auto"),
+				labeledGauge(1, "name", "themes", "start_mode", "disabled"),
+				labeledGauge(1, "name", "notmatched", "start_mode", "auto"),
+				labeledGauge(0, "name", "notmatched", "start_mode", "disabled"),
+			},
+		},
+	}
+}
@@ -0,0 +1,123 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// Policies to choose the entities kept when max_entities is exceeded. Ties are broken alphabetically.
+const (
+	SelectionAlphabetical   = "alphabetical"
+	SelectionAutoStartFirst = "auto_start_first"
+	SelectionRunningFirst   = "running_first"
+)
+
+// EntityLimit caps the number of service entities reported per host. Max 0 means no limit.
+type EntityLimit struct {
+	Max       int
+	Selection string
+}
+
+// droppedServices remembers the services dropped by the entity limit in the previous cycle, so
+// their list is only logged when it changes. It is shared by the copies of the Config.
+type droppedServices struct {
+	sync.Mutex
+	names string
+}
+
+// changed records the names dropped in this cycle and returns true when they differ from the
+// previous ones. A nil droppedServices always reports a change.
+func (d *droppedServices) changed(names string) bool {
+	if d == nil {
+		return true
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.names == names {
+		return false
+	}
+	d.names = names
+	return true
+}
+
+func validSelection(selection string) bool {
+	switch selection {
+	case SelectionAlphabetical, SelectionAutoStartFirst, SelectionRunningFirst:
+		return true
+	}
+	return false
+}
+
+// limitEntities removes from ebn and from the integration the entities exceeding the limit,
+// and returns the names of the services dropped sorted alphabetically. The names are logged
+// when they differ from the ones dropped in the previous cycle.
+func limitEntities(i *integration.Integration, metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, ebn entitiesByName, limit EntityLimit, previous *droppedServices) []string {
+	if limit.Max <= 0 || len(ebn) <= limit.Max {
+		previous.changed("")
+		return nil
+	}
+
+	selected := selectServices(metricFamilyMap, entityRules, ebn, limit.Selection)
+	dropped := selected[limit.Max:]
+	droppedEntities := make(map[*integration.Entity]struct{}, len(dropped))
+	for _, serviceName := range dropped {
+		droppedEntities[ebn[serviceName]] = struct{}{}
+		delete(ebn, serviceName)
+	}
+
+	entities := i.Entities[:0]
+	for _, e := range i.Entities {
+		if _, ok := droppedEntities[e]; !ok {
+			entities = append(entities, e)
+		}
+	}
+	i.Entities = entities
+
+	sort.Strings(dropped)
+	// the names can be a long list, it isn't repeated on every cycle
+	names := strings.Join(dropped, ", ")
+	if previous.changed(names) {
+		log.Warn("max_entities %d exceeded, %d services dropped: %s", limit.Max, len(dropped), names)
+	} else {
+		log.Debug("max_entities %d exceeded, the same %d services dropped", limit.Max, len(dropped))
+	}
+	return dropped
+}
+
+// selectServices sorts the services of ebn in the order they are kept according to the selection policy
+func selectServices(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, ebn entitiesByName, selection string) []string {
+	services := make([]string, 0, len(ebn))
+	for serviceName := range ebn {
+		services = append(services, serviceName)
+	}
+
+	var preferred func(serviceName string) bool
+	switch selection {
+	case SelectionAutoStartFirst:
+		startModes := enumValues(metricFamilyMap, entityRules, serviceStartModeMetric, startModeLabel, ebn, nil)
+		preferred = func(serviceName string) bool { return startModes[serviceName] == autoStartMode }
+	case SelectionRunningFirst:
+		states := enumValues(metricFamilyMap, entityRules, serviceStateMetric, stateLabel, ebn, nil)
+		preferred = func(serviceName string) bool { return states[serviceName] == runningState }
+	default:
+		preferred = func(string) bool { return false }
+	}
+
+	sort.Slice(services, func(a, b int) bool {
+		if pa, pb := preferred(services[a]), preferred(services[b]); pa != pb {
+			return pa
+		}
+		return services[a] < services[b]
+	})
+	return services
+}
@@ -0,0 +1,146 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitFixture has one service preferred by each selection policy:
+// alpha is first alphabetically, bravo is running and charlie starts automatically.
+func limitFixture() scraper.MetricFamiliesByName {
+	return scraper.MetricFamiliesByName{
+		"windows_service_info": &dto.MetricFamily{
+			Name: strPtr("windows_service_info"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "charlie", "display_name", "Charlie"),
+				labeledGauge(1, "name", "bravo", "display_name", "Bravo"),
+				labeledGauge(1, "name", "alpha", "display_name", "Alpha"),
+			},
+		},
+		"windows_service_state": &dto.MetricFamily{
+			Name: strPtr("windows_service_state"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "alpha", "state", "stopped"),
+				labeledGauge(1, "name", "bravo", "state", "running"),
+				labeledGauge(1, "name", "charlie", "state", "stopped"),
+			},
+		},
+		"windows_service_start_mode": &dto.MetricFamily{
+			Name: strPtr("windows_service_start_mode"),
+			Type: &gauge,
+			Metric: []*dto.Metric{
+				labeledGauge(1, "name", "alpha", "start_mode", "disabled"),
+				labeledGauge(1, "name", "bravo", "start_mode", "manual"),
+				labeledGauge(1, "name", "charlie", "start_mode", "auto"),
+			},
+		},
+	}
+}
+
+func TestLimitEntities(t *testing.T) {
+	tests := map[string]struct {
+		limit    EntityLimit
+		kept     []string
+		dropped  []string
+		entities int
+	}{
+		"no limit":         {EntityLimit{Max: 0}, []string{"alpha", "bravo", "charlie"}, nil, 3},
+		"under the limit":  {EntityLimit{Max: 3, Selection: SelectionAlphabetical}, []string{"alpha", "bravo", "charlie"}, nil, 3},
+		"alphabetical":     {EntityLimit{Max: 2, Selection: SelectionAlphabetical}, []string{"alpha", "bravo"}, []string{"charlie"}, 2},
+		"auto start first": {EntityLimit{Max: 1, Selection: SelectionAutoStartFirst}, []string{"charlie"}, []string{"alpha", "bravo"}, 1},
+		"running first":    {EntityLimit{Max: 1, Selection: SelectionRunningFirst}, []string{"bravo"}, []string{"alpha", "charlie"}, 1},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			i, _ := integration.New("integrationName", "integrationVersion")
+			rules := loadRules()
+			mfbn := limitFixture()
+			ebn, err := createEntities(i, mfbn, rules, mustMatcher([]string{`regex ".*"`}), hostName)
+			require.NoError(t, err)
+
+			dropped := limitEntities(i, mfbn, rules, ebn, tt.limit, nil)
+			assert.Equal(t, tt.dropped, dropped)
+			var kept []string
+			for serviceName := range ebn {
+				kept = append(kept, serviceName)
+			}
+			assert.ElementsMatch(t, tt.kept, kept)
+			assert.Len(t, i.Entities, tt.entities)
+			for _, e := range i.Entities {
+				assert.Contains(t, tt.kept, strings.TrimPrefix(e.Name(), entityNamePrefix+":"+hostName+":"))
+			}
+		})
+	}
+}
+
+func TestProcessMetricsReportsDroppedEntities(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher:     mustMatcher([]string{`regex ".*"`}),
+		EntityLimit: EntityLimit{Max: 1, Selection: SelectionRunningFirst},
+	}
+
+	err := ProcessMetrics(i, limitFixture(), config, hostname)
+	require.NoError(t, err)
+	require.Len(t, i.Entities, 1)
+
+	values := make(map[string]float64)
+	for _, m := range i.HostEntity.Metrics {
+		name, value := gaugeNameAndValue(t, m)
+		values[name] = value
+	}
+	assert.Equal(t, float64(2), values[summaryDroppedCount])
+	assert.Equal(t, float64(3), values[summaryMatchedCount])
+}
+
+func TestProcessMetricsSummaryCountsDroppedEntities(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher:     mustMatcher([]string{"rpcss", "spooler", "themes"}),
+		EntityLimit: EntityLimit{Max: 1, Selection: SelectionRunningFirst},
+	}
+
+	err := ProcessMetrics(i, summaryFixture(), config, hostname)
+	require.NoError(t, err)
+	require.Len(t, i.Entities, 1)
+
+	values := make(map[string]float64)
+	for _, m := range i.HostEntity.Metrics {
+		name, value := gaugeNameAndValue(t, m)
+		if d := m.Dimension("state"); d != "" {
+			name += ":" + d
+		}
+		values[name] = value
+	}
+	// spooler starts automatically and is stopped, it is counted though it has been dropped
+	assert.Equal(t, float64(1), values[summaryAutoUnhealthyCount])
+	assert.Equal(t, float64(2), values[summaryStateCount+":stopped"])
+	assert.Equal(t, float64(3), values[summaryMatchedCount])
+	assert.Equal(t, float64(2), values[summaryDroppedCount])
+}
+
+func TestDroppedServicesChanged(t *testing.T) {
+	d := &droppedServices{}
+	assert.True(t, d.changed("alpha, bravo"))
+	assert.False(t, d.changed("alpha, bravo"), "the same services are not logged again")
+	assert.True(t, d.changed("alpha"))
+	assert.True(t, d.changed(""))
+	assert.True(t, d.changed("alpha"), "services dropped again after being kept are logged")
+
+	var unknown *droppedServices
+	assert.True(t, unknown.changed("alpha"))
+}
@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -33,17 +30,30 @@ type metadataMap map[string]string
 type attributesMap map[string]string
 
 // ProcessMetrics creates entities and add metrics from the MetricFamiliesByName according to rules
-func ProcessMetrics(i *integration.Integration, metricFamilyMap scraper.MetricFamiliesByName, matcher matcher.Matcher, hostname string) error {
+// and the services filters, tags and desired states defined in the config.
+func ProcessMetrics(i *integration.Integration, metricFamilyMap scraper.MetricFamiliesByName, config *Config, hostname string) error {
 	entityRules := loadRules()
+	entityRules.omitMetadata(config.OmitMetadata)
 
 	if hostname == "" {
 		return fmt.Errorf("hostname cannot be empty")
 	}
+	// We want the hostEntity to be created because it's needed for the register_batch endpoint.
+	// Publish replaces it with an ignored one, so it's set again on every cycle.
+	i.HostEntity.SetIgnoreEntity(false)
+
+	entityNameHost := config.EntityNameHost
+	if entityNameHost == "" {
+		entityNameHost = hostName
+	}
 
-	entityMap, err := createEntities(i, metricFamilyMap, entityRules, matcher)
+	entityMap, err := createEntities(i, metricFamilyMap, entityRules, config.Matcher, entityNameHost)
 	if err != nil {
 		return err
 	}
+	// the summary counts all the services matching the filters, the ones dropped by the limit included
+	summary := newHostSummary(metricFamilyMap, entityRules, entityMap)
+	dropped := limitEntities(i, metricFamilyMap, entityRules, entityMap, config.EntityLimit, config.droppedServices)
 
 	for _, metricsRules := range entityRules.Metrics {
 		if metricFamily, ok := metricFamilyMap[metricsRules.ProviderName]; ok {
@@ -52,10 +62,25 @@ func ProcessMetrics(i *integration.Integration, metricFamilyMap scraper.MetricFa
 			}
 		}
 	}
+
+	addServiceTags(entityMap, config.ServiceTags)
+	addDependencies(entityMap, newDependencyGraph(dependenciesFrom(metricFamilyMap, entityRules)))
+	addFailureActions(metricFamilyMap, entityRules, entityMap, config.CriticalServices)
+	checkDesiredStates(metricFamilyMap, entityRules, entityMap, config.DesiredStates)
+
+	summary.setDropped(config.EntityLimit, len(dropped))
+	// the host entity is the host running the integration, the summary of a remote instance
+	// would be mixed with the local one. Only its dropped services are reported, told apart
+	// by the hostname dimension.
+	if config.ExporterURL != "" {
+		summary.addDroppedMetric(i.HostEntity, entityRules, hostname)
+		return nil
+	}
+	summary.addMetrics(i.HostEntity, entityRules, hostname)
 	return nil
 }
 
-func findProcessId(metricFamily dto.MetricFamily, key string, entityRules EntityRules) (string, error) {
+func findProcessId(metricFamily *dto.MetricFamily, key string, entityRules EntityRules) (string, error) {
 	for _, m := range metricFamily.GetMetric() {
 		serviceName, _ := getLabelValue(m.GetLabel(), entityRules.EntityName.Label)
 		if serviceName == key {
@@ -69,7 +94,12 @@ func findProcessId(metricFamily dto.MetricFamily, key string, entityRules Entity
 	return "", fmt.Errorf("label %v not found", key)
 }
 
-func createEntities(integrationInstance *integration.Integration, metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, matcher matcher.Matcher) (entitiesByName, error) {
+// familyMetrics returns the metrics of the family metricName, nil when it wasn't reported
+func familyMetrics(metricFamilyMap scraper.MetricFamiliesByName, metricName string) []*dto.Metric {
+	return metricFamilyMap[metricName].GetMetric()
+}
+
+func createEntities(integrationInstance *integration.Integration, metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, matcher matcher.Matcher, entityNameHost string) (entitiesByName, error) {
 	entityMap := make(map[string]*integration.Entity)
 
 	mf, ok := metricFamilyMap[entityRules.EntityName.Metric]
@@ -98,7 +128,7 @@ func createEntities(integrationInstance *integration.Integration, metricFamilyMa
 			continue
 		}
 
-		entityName := fmt.Sprintf("%s:%s:%s", entityNamePrefix, hostName, strings.ToLower(serviceName))
+		entityName := fmt.Sprintf("%s:%s:%s", entityNamePrefix, entityNameHost, strings.ToLower(serviceName))
 
 		entity, err := integrationInstance.NewEntity(entityName, entityRules.EntityType, serviceDisplayName)
 		if err != nil {
@@ -113,7 +143,7 @@ func createEntities(integrationInstance *integration.Integration, metricFamilyMa
 	return entityMap, nil
 }
 
-func processMetricGauge(metricFamily dto.MetricFamily, entityRules EntityRules, ebn entitiesByName, metricFamilyMap scraper.MetricFamiliesByName, hostname string) error {
+func processMetricGauge(metricFamily *dto.MetricFamily, entityRules EntityRules, ebn entitiesByName, metricFamilyMap scraper.MetricFamiliesByName, hostname string) error {
 	metricRules, err := entityRules.getMetricRules(metricFamily.GetName())
 	if err != nil {
 		return fmt.Errorf("metric rule not found")
@@ -200,7 +230,9 @@ func getAttributesAndMetadata(entityRules EntityRules, attributesRules []Attribu
 	for _, attribute := range attributesRules {
 		value, err := getLabelValue(metric.GetLabel(), attribute.Label)
 		if err != nil {
-			log.Warn(err.Error())
+			if !attribute.Optional {
+				log.Warn(err.Error())
+			}
 			continue
 		}
 		nrdbLabelName := attribute.NrdbLabelName
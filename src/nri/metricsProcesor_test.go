@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -31,7 +28,7 @@ const (
 var filter = []string{serviceName}
 var gauge = dto.MetricType_GAUGE
 
-var metricFamlilyServiceInfo = dto.MetricFamily{
+var metricFamlilyServiceInfo = &dto.MetricFamily{
 	Name: strPtr("windows_service_info"),
 	Type: &gauge,
 	Metric: []*dto.Metric{
@@ -56,7 +53,7 @@ var metricFamlilyServiceInfo = dto.MetricFamily{
 		},
 	},
 }
-var metricFamlilyService = dto.MetricFamily{
+var metricFamlilyService = &dto.MetricFamily{
 	Name: strPtr("windows_service_start_mode"),
 	Type: &gauge,
 	Metric: []*dto.Metric{
@@ -93,7 +90,7 @@ var metricFamlilyService = dto.MetricFamily{
 	},
 }
 
-var metricFamlilyServiceProcess = dto.MetricFamily{
+var metricFamlilyServiceProcess = &dto.MetricFamily{
 	Name: strPtr("windows_service_process"),
 	Type: &gauge,
 	Metric: []*dto.Metric{
@@ -123,8 +120,8 @@ func TestCreateEntities(t *testing.T) {
 		"windows_service_start_mode": metricFamlilyService,
 	}
 
-	matcher := matcher.New(filter)
-	entityMap, err := createEntities(i, mfbn, rules, matcher)
+	matcher := mustMatcher(filter)
+	entityMap, err := createEntities(i, mfbn, rules, matcher, hostName)
 	require.NoError(t, err)
 	_, ok := entityMap[serviceName]
 	require.True(t, ok)
@@ -141,8 +138,8 @@ func TestNoServiceNameAllowed(t *testing.T) {
 		"windows_service_start_mode": metricFamlilyService,
 	}
 
-	matcher := matcher.New([]string{})
-	entityMap, err := createEntities(i, mfbn, rules, matcher)
+	matcher := mustMatcher([]string{})
+	entityMap, err := createEntities(i, mfbn, rules, matcher, hostName)
 	require.NoError(t, err, "No error is expected even if no service is allowed")
 	require.Len(t, entityMap, 0, "No entity is expected since no service is allowed")
 	err = processMetricGauge(metricFamlilyService, rules, entityMap, mfbn, hostname)
@@ -160,8 +157,8 @@ func TestProccessMetricGauge(t *testing.T) {
 		"windows_service_process":    metricFamlilyServiceProcess,
 	}
 
-	matcher := matcher.New(filter)
-	entityMap, err := createEntities(i, mfbn, rules, matcher)
+	matcher := mustMatcher(filter)
+	entityMap, err := createEntities(i, mfbn, rules, matcher, hostName)
 	require.NoError(t, err)
 	// process info metrics
 	err = processMetricGauge(metricFamlilyServiceInfo, rules, entityMap, mfbn, hostname)
@@ -184,6 +181,91 @@ func TestProccessMetricGauge(t *testing.T) {
 
 }
 
+func TestProcessMetricsEntityNameHost(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher:        mustMatcher([]string{"spooler"}),
+		EntityNameHost: "sql-01",
+	}
+
+	require.NoError(t, ProcessMetrics(i, summaryFixture(), config, "sql-01"))
+	require.Len(t, i.Entities, 1)
+	assert.Equal(t, "WIN_SERVICE:sql-01:spooler", i.Entities[0].Name())
+	assert.Equal(t, "sql-01", i.Entities[0].GetMetadata()["hostname"])
+}
+
+func configMetadataFixture() scraper.MetricFamiliesByName {
+	mfbn := summaryFixture()
+	info := mfbn["windows_service_info"]
+	info.Metric[0] = labeledGauge(1, "name", "rpcss", "display_name", "RPC", "path_name", `C:\Windows\system32\svchost.exe -k rpcss`)
+	mfbn["windows_service_config"] = &dto.MetricFamily{
+		Name: strPtr("windows_service_config"),
+		Type: &gauge,
+		Metric: []*dto.Metric{
+			labeledGauge(1, "name", "spooler", "image_path", `C:\Windows\System32\spoolsv.exe`, "description", "Print Spooler",
+				"service_type", "own_process", "delayed_auto_start", "false", "sid_type", "unrestricted"),
+		},
+	}
+	return mfbn
+}
+
+func TestProcessMetricsConfigMetadata(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{Matcher: mustMatcher([]string{"rpcss", "spooler", "themes"})}
+	require.NoError(t, ProcessMetrics(i, configMetadataFixture(), config, hostname))
+
+	entities := make(map[string]*integration.Entity)
+	for _, e := range i.Entities {
+		entities[e.GetMetadata()["service_name"].(string)] = e
+	}
+
+	// the image path is taken from the exporter when it reports path_name
+	assert.Equal(t, `C:\Windows\system32\svchost.exe -k rpcss`, entities["rpcss"].GetMetadata()["image_path"])
+
+	spooler := entities["spooler"].GetMetadata()
+	assert.Equal(t, `C:\Windows\System32\spoolsv.exe`, spooler["image_path"])
+	assert.Equal(t, "Print Spooler", spooler["description"])
+	assert.Equal(t, "own_process", spooler["service_type"])
+	assert.Equal(t, "false", spooler["delayed_auto_start"])
+	assert.Equal(t, "unrestricted", spooler["sid_type"])
+
+	assert.Nil(t, entities["themes"].GetMetadata()["image_path"])
+}
+
+func TestProcessMetricsOmitMetadata(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher:      mustMatcher([]string{"rpcss", "spooler"}),
+		OmitMetadata: []string{"image_path", "description"},
+	}
+	require.NoError(t, ProcessMetrics(i, configMetadataFixture(), config, hostname))
+
+	require.Len(t, i.Entities, 2)
+	for _, e := range i.Entities {
+		metadata := e.GetMetadata()
+		assert.Nil(t, metadata["image_path"])
+		assert.Nil(t, metadata["description"])
+		assert.NotNil(t, metadata["display_name"])
+	}
+}
+
+func TestOmitMetadataKeepsOtherRules(t *testing.T) {
+	rules := loadRules()
+	rules.omitMetadata([]string{"sid_type"})
+
+	configRules, err := rules.getMetricRules("windows_service_config")
+	require.NoError(t, err)
+	for _, a := range configRules.Attributes {
+		assert.NotEqual(t, "sid_type", a.NrdbLabelName)
+	}
+	assert.Len(t, configRules.Attributes, 4)
+	// the rules returned by loadRules are not modified
+	original := loadRules()
+	configRules, err = original.getMetricRules("windows_service_config")
+	require.NoError(t, err)
+	assert.Len(t, configRules.Attributes, 5)
+}
+
 func strPtr(s string) *string {
 	return &s
 }
@@ -191,3 +273,12 @@ func strPtr(s string) *string {
 func float64Ptr(f float64) *float64 {
 	return &f
 }
+
+// mustMatcher creates a matcher from valid filters
+func mustMatcher(filters []string) matcher.Matcher {
+	m, err := matcher.New(filters)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
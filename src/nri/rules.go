@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -53,8 +50,13 @@ type Attribute struct {
 	Label            string `yaml:"provider_name"`
 	NrdbLabelName    string `yaml:"nrdb_name"`
 	IsEntityMetadata bool   `yaml:"entity_metadata"` // when true this attribute will be use as metadata.
+	Optional         bool   `yaml:"optional"`        // when true the label can be missing, e.g. not reported by every exporter version.
 }
 
+// omittableMetadata are the metadata that can be left out with omit_metadata, since they may
+// expose sensitive data like the path of the binaries.
+var omittableMetadata = []string{"image_path", "description", "service_type", "delayed_auto_start", "sid_type"}
+
 func loadRules() EntityRules {
 
 	rules := EntityRules{
@@ -86,6 +88,44 @@ func loadRules() EntityRules {
 						NrdbLabelName:    "display_name",
 						IsEntityMetadata: true,
 					},
+					{
+						Label:            "path_name",
+						NrdbLabelName:    "image_path",
+						IsEntityMetadata: true,
+						Optional:         true,
+					},
+				},
+			},
+			{
+				// only reported by the scm metrics source
+				ProviderName: "windows_service_config",
+				InfoMetric:   true,
+				Attributes: []Attribute{
+					{
+						Label:            "image_path",
+						NrdbLabelName:    "image_path",
+						IsEntityMetadata: true,
+					},
+					{
+						Label:            "description",
+						NrdbLabelName:    "description",
+						IsEntityMetadata: true,
+					},
+					{
+						Label:            "service_type",
+						NrdbLabelName:    "service_type",
+						IsEntityMetadata: true,
+					},
+					{
+						Label:            "delayed_auto_start",
+						NrdbLabelName:    "delayed_auto_start",
+						IsEntityMetadata: true,
+					},
+					{
+						Label:            "sid_type",
+						NrdbLabelName:    "sid_type",
+						IsEntityMetadata: true,
+					},
 				},
 			},
 			{
@@ -145,3 +185,24 @@ func (r *EntityRules) getMetricRules(providerName string) (*MetricRules, error)
 	}
 	return nil, fmt.Errorf("no rules find for providerName: %s", providerName)
 }
+
+// omitMetadata removes the entity metadata attributes named in metadata from the rules
+func (r *EntityRules) omitMetadata(metadata []string) {
+	if len(metadata) == 0 {
+		return
+	}
+	omitted := make(map[string]struct{}, len(metadata))
+	for _, m := range metadata {
+		omitted[m] = struct{}{}
+	}
+	for idx := range r.Metrics {
+		attributes := make([]Attribute, 0, len(r.Metrics[idx].Attributes))
+		for _, a := range r.Metrics[idx].Attributes {
+			if _, ok := omitted[a.NrdbLabelName]; ok && a.IsEntityMetadata {
+				continue
+			}
+			attributes = append(attributes, a)
+		}
+		r.Metrics[idx].Attributes = attributes
+	}
+}
@@ -0,0 +1,162 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"sort"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+const (
+	serviceStateMetric     = "windows_service_state"
+	serviceStartModeMetric = "windows_service_start_mode"
+	stateLabel             = "state"
+	startModeLabel         = "start_mode"
+	autoStartMode          = "auto"
+	runningState           = "running"
+
+	summaryStateCount         = "windows_services_state_count"
+	summaryStartModeCount     = "windows_services_start_mode_count"
+	summaryMatchedCount       = "windows_services_matched_count"
+	summaryTotalCount         = "windows_services_total_count"
+	summaryAutoUnhealthyCount = "windows_services_auto_unhealthy_count"
+	summaryDroppedCount       = "windows_services_dropped_count"
+	summarySkippedScrapes     = "windows_services_skipped_scrapes_count"
+)
+
+// hostSummary aggregates the services found on the host. State and start mode counts only
+// take into account the services matching the filters, so they are consistent with the entities.
+type hostSummary struct {
+	total         int
+	matched       int
+	autoUnhealthy int
+	byState       map[string]int
+	byStartMode   map[string]int
+	// limited is true when max_entities is configured, then the dropped services are reported
+	limited bool
+	dropped int
+}
+
+// newHostSummary builds the summary from the scraped metrics and the entities created for the
+// services matching the filters, before the entity limit is applied.
+func newHostSummary(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, ebn entitiesByName) hostSummary {
+	s := hostSummary{
+		matched:     len(ebn),
+		byState:     make(map[string]int),
+		byStartMode: make(map[string]int),
+	}
+
+	services := make(map[string]struct{})
+	for _, m := range familyMetrics(metricFamilyMap, entityRules.EntityName.Metric) {
+		if serviceName, err := getLabelValue(m.GetLabel(), entityRules.EntityName.Label); err == nil {
+			services[serviceName] = struct{}{}
+		}
+	}
+	s.total = len(services)
+
+	states := enumValues(metricFamilyMap, entityRules, serviceStateMetric, stateLabel, ebn, s.byState)
+	startModes := enumValues(metricFamilyMap, entityRules, serviceStartModeMetric, startModeLabel, ebn, s.byStartMode)
+	for serviceName, startMode := range startModes {
+		if startMode == autoStartMode && states[serviceName] != runningState {
+			s.autoUnhealthy++
+		}
+	}
+	return s
+}
+
+// enumValues returns the active value of an enum metric for each matched service and counts
+// them in counters when not nil. Every value reported by the exporter is registered, so counters
+// also contain the values with no services.
+func enumValues(metricFamilyMap scraper.MetricFamiliesByName, entityRules EntityRules, metricName, label string, ebn entitiesByName, counters map[string]int) map[string]string {
+	values := make(map[string]string)
+	for _, m := range familyMetrics(metricFamilyMap, metricName) {
+		value, err := getLabelValue(m.GetLabel(), label)
+		if err != nil {
+			continue
+		}
+		if _, ok := counters[value]; !ok && counters != nil {
+			counters[value] = 0
+		}
+		if m.GetGauge().GetValue() != 1 {
+			continue
+		}
+		serviceName, err := getLabelValue(m.GetLabel(), entityRules.EntityName.Label)
+		if err != nil {
+			continue
+		}
+		if _, ok := ebn[serviceName]; !ok {
+			continue
+		}
+		values[serviceName] = value
+		if counters != nil {
+			counters[value]++
+		}
+	}
+	return values
+}
+
+// setDropped registers the services matching the filters that were dropped by the entity limit,
+// the summary was built before so they are still counted.
+func (s *hostSummary) setDropped(limit EntityLimit, dropped int) {
+	s.limited = limit.Max > 0
+	s.dropped = dropped
+}
+
+// addMetrics adds the summary as gauges to the given entity, usually the host entity.
+func (s hostSummary) addMetrics(e *integration.Entity, entityRules EntityRules, hostname string) {
+	now := time.Now()
+	addGauge := func(name string, value int, dimensions attributesMap) {
+		addHostGauge(e, entityRules, hostname, now, name, float64(value), dimensions)
+	}
+
+	for _, state := range sortedKeys(s.byState) {
+		addGauge(summaryStateCount, s.byState[state], attributesMap{stateLabel: state})
+	}
+	for _, startMode := range sortedKeys(s.byStartMode) {
+		addGauge(summaryStartModeCount, s.byStartMode[startMode], attributesMap{startModeLabel: startMode})
+	}
+	addGauge(summaryMatchedCount, s.matched, attributesMap{})
+	addGauge(summaryTotalCount, s.total, attributesMap{})
+	addGauge(summaryAutoUnhealthyCount, s.autoUnhealthy, attributesMap{})
+	s.addDroppedMetric(e, entityRules, hostname)
+}
+
+// addDroppedMetric adds the services dropped by the entity limit as a gauge to the given entity
+// when max_entities is configured.
+func (s hostSummary) addDroppedMetric(e *integration.Entity, entityRules EntityRules, hostname string) {
+	if s.limited {
+		addHostGauge(e, entityRules, hostname, time.Now(), summaryDroppedCount, float64(s.dropped), attributesMap{})
+	}
+}
+
+// AddSkippedScrapes adds to the host entity the scrapes skipped so far because the previous one
+// was still running, next to the host summary.
+func AddSkippedScrapes(i *integration.Integration, hostname string, skipped uint64) {
+	addHostGauge(i.HostEntity, loadRules(), hostname, time.Now(), summarySkippedScrapes, float64(skipped), attributesMap{})
+}
+
+func addHostGauge(e *integration.Entity, entityRules EntityRules, hostname string, now time.Time, name string, value float64, dimensions attributesMap) {
+	gauge, err := integration.Gauge(now, name, value)
+	if err != nil {
+		warnOnErr(err)
+		return
+	}
+	dimensions[entityRules.EntityName.HostnameNrdbLabelName] = hostname
+	addAttributes(dimensions, gauge)
+	e.AddMetric(gauge)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gaugeNameAndValue decodes the serialized gauge since the sdk does not expose its fields.
+func gaugeNameAndValue(t *testing.T, m metric.Metric) (string, float64) {
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	var g struct {
+		Name  string  `json:"name"`
+		Value float64 `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(b, &g))
+	return g.Name, g.Value
+}
+
+func TestNewHostSummary(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	rules := loadRules()
+	mfbn := summaryFixture()
+
+	entityMap, err := createEntities(i, mfbn, rules, mustMatcher([]string{"rpcss", "spooler", "themes"}), hostName)
+	require.NoError(t, err)
+
+	s := newHostSummary(mfbn, rules, entityMap)
+	assert.Equal(t, 4, s.total)
+	assert.Equal(t, 3, s.matched)
+	assert.Equal(t, 1, s.autoUnhealthy)
+	assert.Equal(t, map[string]int{"running": 1, "stopped": 2}, s.byState)
+	assert.Equal(t, map[string]int{"auto": 2, "disabled": 1}, s.byStartMode)
+}
+
+func TestProcessMetricsAddsHostSummary(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+
+	config := &Config{Matcher: mustMatcher([]string{`regex "^(rpcss|spooler)$"`})}
+	err := ProcessMetrics(i, summaryFixture(), config, hostname)
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, m := range i.HostEntity.Metrics {
+		require.Equal(t, hostname, m.Dimension("hostname"))
+		name, value := gaugeNameAndValue(t, m)
+		if d := m.Dimension("state"); d != "" {
+			name += ":" + d
+		}
+		if d := m.Dimension("start_mode"); d != "" {
+			name += ":" + d
+		}
+		values[name] = value
+	}
+
+	assert.Equal(t, map[string]float64{
+		"windows_services_state_count:running":       1,
+		"windows_services_state_count:stopped":       1,
+		"windows_services_start_mode_count:auto":     2,
+		"windows_services_start_mode_count:disabled": 0,
+		"windows_services_matched_count":             2,
+		"windows_services_total_count":               4,
+		"windows_services_auto_unhealthy_count":      1,
+	}, values)
+}
+
+func TestProcessMetricsHostSummaryEveryCycle(t *testing.T) {
+	var buf bytes.Buffer
+	i, err := integration.New("integrationName", "integrationVersion", integration.Writer(&buf))
+	require.NoError(t, err)
+
+	config := &Config{Matcher: mustMatcher([]string{`regex "^(rpcss|spooler)$"`})}
+	for cycle := 0; cycle < 2; cycle++ {
+		require.NoError(t, ProcessMetrics(i, summaryFixture(), config, hostname))
+		require.NoError(t, i.Publish())
+	}
+
+	// Publish resets the host entity, the summary must not be attached to an ignored one afterwards
+	scanner := bufio.NewScanner(&buf)
+	cycles := 0
+	for ; scanner.Scan(); cycles++ {
+		var payload struct {
+			Data []struct {
+				Entity       *json.RawMessage  `json:"entity"`
+				IgnoreEntity bool              `json:"ignore_entity"`
+				Metrics      []json.RawMessage `json:"metrics"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &payload))
+		require.Len(t, payload.Data, 3, "cycle %d", cycles)
+		host := payload.Data[2]
+		assert.Nil(t, host.Entity, "cycle %d", cycles)
+		assert.False(t, host.IgnoreEntity, "cycle %d", cycles)
+		assert.Len(t, host.Metrics, 7, "cycle %d", cycles)
+	}
+	assert.Equal(t, 2, cycles)
+}
+
+func TestProcessMetricsSkipsHostSummaryOfInstances(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+
+	config := &Config{
+		Matcher:     mustMatcher([]string{`regex "^(rpcss|spooler)$"`}),
+		ExporterURL: "http://10.0.0.5:9182/metrics",
+		Hostname:    "sql-01",
+	}
+	err := ProcessMetrics(i, summaryFixture(), config, "sql-01")
+	require.NoError(t, err)
+	assert.Len(t, i.Entities, 2)
+	assert.Empty(t, i.HostEntity.Metrics)
+}
+
+func TestProcessMetricsReportsDroppedEntitiesOfInstances(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+
+	config := &Config{
+		Matcher:     mustMatcher([]string{`regex "^(rpcss|spooler)$"`}),
+		ExporterURL: "http://10.0.0.5:9182/metrics",
+		Hostname:    "sql-01",
+		EntityLimit: EntityLimit{Max: 1, Selection: SelectionAlphabetical},
+	}
+	err := ProcessMetrics(i, summaryFixture(), config, "sql-01")
+	require.NoError(t, err)
+	assert.Len(t, i.Entities, 1)
+
+	// only the dropped services are reported for an instance, the rest of the summary is left out
+	require.Len(t, i.HostEntity.Metrics, 1)
+	name, value := gaugeNameAndValue(t, i.HostEntity.Metrics[0])
+	assert.Equal(t, summaryDroppedCount, name)
+	assert.Equal(t, float64(1), value)
+	assert.Equal(t, "sql-01", i.HostEntity.Metrics[0].Dimension("hostname"))
+}
+
+func TestAddSkippedScrapes(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+
+	AddSkippedScrapes(i, hostname, 3)
+	require.Len(t, i.HostEntity.Metrics, 1)
+	name, value := gaugeNameAndValue(t, i.HostEntity.Metrics[0])
+	assert.Equal(t, "windows_services_skipped_scrapes_count", name)
+	assert.Equal(t, float64(3), value)
+	assert.Equal(t, hostname, i.HostEntity.Metrics[0].Dimension("hostname"))
+}
@@ -0,0 +1,61 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/matcher"
+)
+
+// tagsPrefix is the prefix the sdk adds to entity tags, also used for the metric dimensions
+const tagsPrefix = "tags."
+
+// TagRule adds Tags to the services matching the Matcher
+type TagRule struct {
+	Matcher matcher.Matcher
+	Tags    map[string]string
+}
+
+// ServiceTags holds the tag rules in the order they are configured
+type ServiceTags []TagRule
+
+// tagsFor merges the tags of every rule matching the service. Rules are applied in order,
+// so when several rules define the same key the last one wins.
+func (st ServiceTags) tagsFor(serviceName string) map[string]string {
+	var tags map[string]string
+	for _, rule := range st {
+		if !rule.Matcher.Match(serviceName) {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		for k, v := range rule.Tags {
+			if old, ok := tags[k]; ok && old != v {
+				log.Debug("tag %s of service %s overridden: %s -> %s", k, serviceName, old, v)
+			}
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// addServiceTags adds the tags of each service to its entity metadata and as dimensions to
+// all the metrics of the entity.
+func addServiceTags(ebn entitiesByName, serviceTags ServiceTags) {
+	if len(serviceTags) == 0 {
+		return
+	}
+	for serviceName, e := range ebn {
+		tags := serviceTags.tagsFor(serviceName)
+		for k, v := range tags {
+			warnOnErr(e.AddTag(k, v))
+			for _, m := range e.Metrics {
+				warnOnErr(m.AddDimension(tagsPrefix+k, v))
+			}
+		}
+	}
+}
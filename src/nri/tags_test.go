@@ -0,0 +1,69 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package nri
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testServiceTags = ServiceTags{
+	{
+		Matcher: mustMatcher([]string{`regex ".*"`}),
+		Tags:    map[string]string{"team": "platform", "tier": "3"},
+	},
+	{
+		Matcher: mustMatcher([]string{`regex "^(rpcss|spooler)$"`}),
+		Tags:    map[string]string{"tier": "1", "runbook": "https://runbooks/core"},
+	},
+	{
+		Matcher: mustMatcher([]string{"spooler"}),
+		Tags:    map[string]string{"team": "printing"},
+	},
+}
+
+func TestTagsForMergesRulesInOrder(t *testing.T) {
+	assert.Equal(t, map[string]string{"team": "platform", "tier": "1", "runbook": "https://runbooks/core"}, testServiceTags.tagsFor("rpcss"))
+	assert.Equal(t, map[string]string{"team": "printing", "tier": "1", "runbook": "https://runbooks/core"}, testServiceTags.tagsFor("Spooler"))
+	assert.Equal(t, map[string]string{"team": "platform", "tier": "3"}, testServiceTags.tagsFor("themes"))
+	assert.Nil(t, ServiceTags{}.tagsFor("rpcss"))
+}
+
+func TestProcessMetricsAddsServiceTags(t *testing.T) {
+	i, _ := integration.New("integrationName", "integrationVersion")
+	config := &Config{
+		Matcher:     mustMatcher([]string{"spooler", "themes"}),
+		ServiceTags: testServiceTags,
+	}
+
+	require.NoError(t, ProcessMetrics(i, summaryFixture(), config, hostname))
+	require.Len(t, i.Entities, 2)
+
+	for _, e := range i.Entities {
+		metadata := e.GetMetadata()
+		require.NotEmpty(t, e.Metrics)
+		switch metadata["service_name"] {
+		case "spooler":
+			assert.Equal(t, "printing", metadata["tags.team"])
+			assert.Equal(t, "1", metadata["tags.tier"])
+			for _, m := range e.Metrics {
+				assert.Equal(t, "printing", m.Dimension("tags.team"))
+				assert.Equal(t, "https://runbooks/core", m.Dimension("tags.runbook"))
+			}
+		case "themes":
+			assert.Equal(t, "platform", metadata["tags.team"])
+			assert.Nil(t, metadata["tags.runbook"])
+			for _, m := range e.Metrics {
+				assert.Equal(t, "3", m.Dimension("tags.tier"))
+			}
+		default:
+			t.Fatalf("unexpected entity %s", e.Name())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package otlp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/payload"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const (
+	entityNameAttribute        = "entity.name"
+	entityTypeAttribute        = "entity.type"
+	entityDisplayNameAttribute = "entity.display_name"
+	hostNameAttribute          = "host.name"
+)
+
+// Convert translates the payload published by the integration into an OTLP export request.
+// Each entity becomes a resource whose attributes are the entity name, type, display name
+// and metadata. Every resource, the host one included, has the host.name attribute when the
+// hostname is known. Only gauges are converted.
+func Convert(b []byte, hostname string) (*colmetricspb.ExportMetricsServiceRequest, error) {
+	p, err := payload.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &commonpb.InstrumentationScope{
+		Name:    p.Integration.Name,
+		Version: p.Integration.Version,
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	for _, e := range p.Data {
+		req.ResourceMetrics = append(req.ResourceMetrics, convertEntity(e, hostname, scope))
+	}
+	return req, nil
+}
+
+func convertEntity(se payload.Entity, hostname string, scope *commonpb.InstrumentationScope) *metricspb.ResourceMetrics {
+	resource := &resourcepb.Resource{}
+	if hostname != "" {
+		resource.Attributes = append(resource.Attributes, stringAttribute(hostNameAttribute, hostname))
+	}
+	if !se.IsHost() {
+		resource.Attributes = append(resource.Attributes,
+			stringAttribute(entityNameAttribute, se.Metadata.Name),
+			stringAttribute(entityTypeAttribute, se.Metadata.Type),
+			stringAttribute(entityDisplayNameAttribute, se.Metadata.DisplayName),
+		)
+		for _, k := range sortedKeys(se.Metadata.Metadata) {
+			resource.Attributes = append(resource.Attributes, stringAttribute(k, fmt.Sprint(se.Metadata.Metadata[k])))
+		}
+	}
+
+	// data points with the same name are grouped under the same metric keeping the original order
+	var metrics []*metricspb.Metric
+	byName := make(map[string]*metricspb.Gauge)
+	for _, m := range se.Metrics {
+		if m.Type != payload.GaugeType || m.Value == nil {
+			log.Debug("metric %s of type %s is not exported to otlp", m.Name, m.Type)
+			continue
+		}
+		g, ok := byName[m.Name]
+		if !ok {
+			g = &metricspb.Gauge{}
+			byName[m.Name] = g
+			metrics = append(metrics, &metricspb.Metric{
+				Name: m.Name,
+				Data: &metricspb.Metric_Gauge{Gauge: g},
+			})
+		}
+		dp := &metricspb.NumberDataPoint{
+			TimeUnixNano: uint64(m.Timestamp) * 1e9, //nolint:gosec
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: *m.Value},
+		}
+		for _, k := range sortedKeys(m.Attributes) {
+			dp.Attributes = append(dp.Attributes, stringAttribute(k, m.Attributes[k]))
+		}
+		g.DataPoints = append(g.DataPoints, dp)
+	}
+
+	return &metricspb.ResourceMetrics{
+		Resource: resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{
+			{
+				Scope:   scope,
+				Metrics: metrics,
+			},
+		},
+	}
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
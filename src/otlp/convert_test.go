@@ -0,0 +1,101 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+var timestamp = time.Unix(1600000000, 0)
+
+// testPayload returns the payload published by an integration with a service and a host metric
+func testPayload(t *testing.T) []byte {
+	var payload bytes.Buffer
+	i, err := integration.New("com.newrelic.winservices", "v1.0.0", integration.Writer(&payload))
+	require.NoError(t, err)
+
+	e, err := i.NewEntity("WIN_SERVICE:localhost:rpcss", "WIN_SERVICE", "Remote Procedure Call (RPC)")
+	require.NoError(t, err)
+	require.NoError(t, e.AddMetadata("service_name", "rpcss"))
+	require.NoError(t, e.AddMetadata("hostname", "test-hostname"))
+
+	for _, state := range []string{"running", "stopped"} {
+		g, err := integration.Gauge(timestamp, "windows_service_state", 1)
+		require.NoError(t, err)
+		require.NoError(t, g.AddDimension("state", state))
+		e.AddMetric(g)
+	}
+	c, err := integration.Count(timestamp, "not_exported", 1)
+	require.NoError(t, err)
+	e.AddMetric(c)
+	i.AddEntity(e)
+
+	g, err := integration.Gauge(timestamp, "windows_services_matched_count", 1)
+	require.NoError(t, err)
+	i.HostEntity.AddMetric(g)
+
+	require.NoError(t, i.Publish())
+	return payload.Bytes()
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range kvs {
+		m[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return m
+}
+
+func TestConvert(t *testing.T) {
+	req, err := Convert(testPayload(t), "test-hostname")
+	require.NoError(t, err)
+	require.Len(t, req.ResourceMetrics, 2)
+
+	service := req.ResourceMetrics[0]
+	assert.Equal(t, map[string]string{
+		"entity.name":         "WIN_SERVICE:localhost:rpcss",
+		"entity.type":         "WIN_SERVICE",
+		"entity.display_name": "Remote Procedure Call (RPC)",
+		"service_name":        "rpcss",
+		"hostname":            "test-hostname",
+		"host.name":           "test-hostname",
+	}, attributes(service.GetResource().GetAttributes()))
+
+	require.Len(t, service.ScopeMetrics, 1)
+	assert.Equal(t, "com.newrelic.winservices", service.ScopeMetrics[0].GetScope().GetName())
+	metrics := service.ScopeMetrics[0].GetMetrics()
+	require.Len(t, metrics, 1, "data points must be grouped by name and non gauges skipped")
+	assert.Equal(t, "windows_service_state", metrics[0].GetName())
+	dps := metrics[0].GetGauge().GetDataPoints()
+	require.Len(t, dps, 2)
+	assert.Equal(t, 1.0, dps[0].GetAsDouble())
+	assert.Equal(t, uint64(timestamp.UnixNano()), dps[0].GetTimeUnixNano())
+	assert.Equal(t, map[string]string{"state": "running"}, attributes(dps[0].GetAttributes()))
+	assert.Equal(t, map[string]string{"state": "stopped"}, attributes(dps[1].GetAttributes()))
+
+	host := req.ResourceMetrics[1]
+	assert.Equal(t, map[string]string{"host.name": "test-hostname"}, attributes(host.GetResource().GetAttributes()))
+	assert.Equal(t, "windows_services_matched_count", host.ScopeMetrics[0].GetMetrics()[0].GetName())
+}
+
+func TestConvertUnknownHostname(t *testing.T) {
+	req, err := Convert(testPayload(t), "")
+	require.NoError(t, err)
+	require.Len(t, req.ResourceMetrics, 2)
+	assert.Empty(t, req.ResourceMetrics[1].GetResource().GetAttributes())
+}
+
+func TestConvertInvalidPayload(t *testing.T) {
+	_, err := Convert([]byte("{}\n{}"), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/sink"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ProtocolHTTP sends protobuf encoded payloads using OTLP/HTTP
+	ProtocolHTTP = "http"
+	// ProtocolGRPC sends payloads using OTLP/gRPC
+	ProtocolGRPC = "grpc"
+
+	protobufContentType = "application/x-protobuf"
+)
+
+// Config holds the OTLP output configuration
+type Config struct {
+	// Endpoint is the full URL for http, e.g. http://localhost:4318/v1/metrics,
+	// and host:port for grpc, e.g. localhost:4317.
+	Endpoint string
+	Protocol string
+	Insecure bool // disables TLS for grpc, for http it is given by the endpoint scheme
+	Headers  map[string]string
+	Timeout  time.Duration
+}
+
+// Exporter pushes the integration payload to an OTLP receiver. It implements sink.Sink
+type Exporter struct {
+	config     Config
+	httpClient *http.Client
+	grpcConn   *grpc.ClientConn
+	grpcClient colmetricspb.MetricsServiceClient
+}
+
+// New creates an Exporter for the configured protocol. No connection is established until the first export.
+func New(config Config) (*Exporter, error) {
+	e := &Exporter{config: config}
+
+	switch config.Protocol {
+	case ProtocolHTTP:
+		e.httpClient = &http.Client{Timeout: config.Timeout}
+	case ProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if config.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(config.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp grpc client:%v", err)
+		}
+		e.grpcConn = conn
+		e.grpcClient = colmetricspb.NewMetricsServiceClient(conn)
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol: %s", config.Protocol)
+	}
+
+	return e, nil
+}
+
+// Name identifies the exporter in logs
+func (e *Exporter) Name() string {
+	return "otlp"
+}
+
+// Write converts the payload published by the integration and sends it to the endpoint.
+func (e *Exporter) Write(ctx context.Context, payload []byte) error {
+	req, err := Convert(payload, sink.Hostname(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to convert integration to otlp:%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	t := time.Now()
+	if e.grpcClient != nil {
+		err = e.exportGRPC(ctx, req)
+	} else {
+		err = e.exportHTTP(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug("Metrics exported to otlp endpoint %s, resources: %d, time elapsed: %s", e.config.Endpoint, len(req.ResourceMetrics), time.Since(t).String())
+	return nil
+}
+
+func (e *Exporter) exportHTTP(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal otlp request:%v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", protobufContentType)
+	for k, v := range e.config.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := e.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send otlp request:%v", err)
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the otlp endpoint answered with status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *Exporter) exportGRPC(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	if len(e.config.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.config.Headers))
+	}
+	resp, err := e.grpcClient.Export(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to send otlp request:%v", err)
+	}
+	if ps := resp.GetPartialSuccess(); ps != nil && ps.GetRejectedDataPoints() > 0 {
+		log.Warn("otlp endpoint rejected %d data points: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+	return nil
+}
+
+// Close releases the connection held by the exporter
+func (e *Exporter) Close() error {
+	if e.grpcConn != nil {
+		return e.grpcConn.Close()
+	}
+	return nil
+}
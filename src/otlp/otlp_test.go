@@ -0,0 +1,111 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-winservices/src/sink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExportHTTP(t *testing.T) {
+	var received colmetricspb.ExportMetricsServiceRequest
+	var apiKey, contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("Api-Key")
+		contentType = r.Header.Get("Content-Type")
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(body, &received))
+	}))
+	defer ts.Close()
+
+	e, err := New(Config{
+		Endpoint: ts.URL + "/v1/metrics",
+		Protocol: ProtocolHTTP,
+		Headers:  map[string]string{"Api-Key": "secret"},
+		Timeout:  time.Second,
+	})
+	require.NoError(t, err)
+	defer e.Close()
+
+	require.NoError(t, e.Write(sink.WithHostname(context.Background(), "test-hostname"), testPayload(t)))
+	assert.Equal(t, "secret", apiKey)
+	assert.Equal(t, protobufContentType, contentType)
+	require.Len(t, received.ResourceMetrics, 2)
+	assert.Equal(t, map[string]string{"host.name": "test-hostname"}, attributes(received.ResourceMetrics[1].GetResource().GetAttributes()))
+}
+
+func TestExportHTTPErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	e, err := New(Config{Endpoint: ts.URL, Protocol: ProtocolHTTP, Timeout: time.Second})
+	require.NoError(t, err)
+
+	err = e.Write(context.Background(), testPayload(t))
+	assert.Error(t, err)
+}
+
+type fakeReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	received chan *colmetricspb.ExportMetricsServiceRequest
+	apiKey   chan []string
+}
+
+func (f *fakeReceiver) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.apiKey <- md.Get("api-key")
+	f.received <- req
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestExportGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	receiver := &fakeReceiver{
+		received: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		apiKey:   make(chan []string, 1),
+	}
+	s := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(s, receiver)
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	e, err := New(Config{
+		Endpoint: lis.Addr().String(),
+		Protocol: ProtocolGRPC,
+		Insecure: true,
+		Headers:  map[string]string{"api-key": "secret"},
+		Timeout:  5 * time.Second,
+	})
+	require.NoError(t, err)
+	defer e.Close()
+
+	require.NoError(t, e.Write(context.Background(), testPayload(t)))
+	assert.Equal(t, []string{"secret"}, <-receiver.apiKey)
+	assert.Len(t, (<-receiver.received).ResourceMetrics, 2)
+}
+
+func TestNewUnsupportedProtocol(t *testing.T) {
+	_, err := New(Config{Endpoint: "localhost:4317", Protocol: "udp"})
+	assert.Error(t, err)
+}
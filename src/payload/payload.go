@@ -0,0 +1,61 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// GaugeType is the type of the gauge metrics in the payload
+const GaugeType = "gauge"
+
+// Payload mirrors the fields of the payload published by the integration, since the sdk
+// does not expose metric names and values once they are added to an entity.
+type Payload struct {
+	Integration struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"integration"`
+	Data []Entity `json:"data"`
+}
+
+// Entity is an entity of the payload, Metadata is nil for the host entity
+type Entity struct {
+	Metadata *EntityMetadata `json:"entity"`
+	Metrics  []Metric        `json:"metrics"`
+}
+
+// EntityMetadata identifies the entity
+type EntityMetadata struct {
+	Name        string                 `json:"name"`
+	DisplayName string                 `json:"displayName"`
+	Type        string                 `json:"type"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// Metric is a metric of an entity. Value is nil for metrics without a single value.
+type Metric struct {
+	Timestamp  int64             `json:"timestamp"`
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Attributes map[string]string `json:"attributes"`
+	Value      *float64          `json:"value"`
+}
+
+// IsHost returns true for the host entity
+func (e Entity) IsHost() bool {
+	return e.Metadata == nil || e.Metadata.Name == ""
+}
+
+// Decode parses the payload published by the integration
+func Decode(b []byte) (Payload, error) {
+	var p Payload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return p, nil
+}
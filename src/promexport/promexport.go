@@ -0,0 +1,222 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package promexport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/payload"
+	"github.com/newrelic/nri-winservices/src/sink"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// entityNameLabel holds the name of the entity the metric belongs to
+	entityNameLabel   = "entity_name"
+	shutdownTimeout   = 5 * time.Second
+	readHeaderTimeout = 10 * time.Second
+)
+
+var (
+	invalidLabelChars  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+)
+
+// Server serves the latest payload published by the integration for each host as Prometheus
+// metrics, each instance publishes its own payload. It implements sink.Sink so it receives exactly
+// the entities created by ProcessMetrics.
+type Server struct {
+	mu       sync.RWMutex
+	payloads map[string]payload.Payload
+	body     []byte
+	listener net.Listener
+	server   *http.Server
+}
+
+// New creates a Server listening on address, serving the metrics on path
+func New(address, path string) (*Server, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s:%v", address, err)
+	}
+
+	s := &Server{listener: lis, payloads: make(map[string]payload.Payload)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.serveMetrics)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	go func() {
+		if err := s.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("prometheus listener stopped:%v", err)
+		}
+	}()
+	log.Debug("Serving prometheus metrics on %s%s", lis.Addr().String(), path)
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Name identifies the sink in logs
+func (s *Server) Name() string {
+	return "prometheus:" + s.Addr()
+}
+
+// Write keeps the payload to be served, with the latest ones of the other hosts, until the next
+// one of the same host is published. The host is the one set by the publisher since a payload with
+// no entities, e.g. when no service matches, doesn't tell it. Such payload clears the host metrics.
+func (s *Server) Write(ctx context.Context, b []byte) error {
+	p, err := payload.Decode(b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hostname := sink.Hostname(ctx)
+	if len(p.Data) == 0 {
+		delete(s.payloads, hostname)
+	} else {
+		s.payloads[hostname] = p
+	}
+
+	hostnames := make([]string, 0, len(s.payloads))
+	for hostname := range s.payloads {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	var all payload.Payload
+	for _, hostname := range hostnames {
+		all.Data = append(all.Data, s.payloads[hostname].Data...)
+	}
+
+	var body bytes.Buffer
+	enc := expfmt.NewEncoder(&body, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range Convert(all) {
+		if err = enc.Encode(mf); err != nil {
+			return fmt.Errorf("failed to encode %s:%v", mf.GetName(), err)
+		}
+	}
+	s.body = body.Bytes()
+	return nil
+}
+
+// Close stops the listener
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	body := s.body
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	_, _ = w.Write(body)
+}
+
+// Convert translates the gauges of the payload into metric families sorted by name. Each sample
+// is labeled with the metric attributes, the entity metadata and the entity name.
+func Convert(p payload.Payload) []*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily)
+	for _, e := range p.Data {
+		entityLabels := make(map[string]string)
+		if !e.IsHost() {
+			for k, v := range e.Metadata.Metadata {
+				entityLabels[labelName(k)] = fmt.Sprint(v)
+			}
+			entityLabels[entityNameLabel] = e.Metadata.Name
+		}
+
+		for _, m := range e.Metrics {
+			if m.Type != payload.GaugeType || m.Value == nil {
+				continue
+			}
+			labels := make(map[string]string, len(entityLabels)+len(m.Attributes))
+			for k, v := range entityLabels {
+				labels[k] = v
+			}
+			// metric attributes take precedence over the entity metadata
+			for k, v := range m.Attributes {
+				labels[labelName(k)] = v
+			}
+
+			name := metricName(m.Name)
+			mf, ok := families[name]
+			if !ok {
+				mf = &dto.MetricFamily{
+					Name: proto.String(name),
+					Type: dto.MetricType_GAUGE.Enum(),
+				}
+				families[name] = mf
+			}
+			mf.Metric = append(mf.Metric, &dto.Metric{
+				Label: labelPairs(labels),
+				Gauge: &dto.Gauge{Value: proto.Float64(*m.Value)},
+			})
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		result = append(result, families[name])
+	}
+	return result
+}
+
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]*dto.LabelPair, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(labels[name])})
+	}
+	return pairs
+}
+
+// labelName replaces the characters not allowed in Prometheus label names
+func labelName(s string) string {
+	return leadingDigit(invalidLabelChars.ReplaceAllString(s, "_"))
+}
+
+// metricName replaces the characters not allowed in Prometheus metric names
+func metricName(s string) string {
+	return leadingDigit(invalidMetricChars.ReplaceAllString(s, "_"))
+}
+
+// leadingDigit prefixes the names starting with a digit, which Prometheus doesn't allow
+func leadingDigit(s string) string {
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package promexport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/sink"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPayload(t *testing.T, hostname string, state float64) []byte {
+	var payload bytes.Buffer
+	i, err := integration.New("com.newrelic.winservices", "v1.0.0", integration.Writer(&payload))
+	require.NoError(t, err)
+
+	e, err := i.NewEntity("WIN_SERVICE:"+hostname+":rpcss", "WIN_SERVICE", "Remote Procedure Call (RPC)")
+	require.NoError(t, err)
+	require.NoError(t, e.AddMetadata("hostname", hostname))
+	require.NoError(t, e.AddMetadata("service_name", "rpcss"))
+	require.NoError(t, e.AddMetadata("display_name", "Remote Procedure Call (RPC)"))
+	require.NoError(t, e.AddMetadata("tags.team", "core"))
+	g, err := integration.Gauge(time.Now(), "windows_service_state", state)
+	require.NoError(t, err)
+	require.NoError(t, g.AddDimension("state", "running"))
+	e.AddMetric(g)
+	i.AddEntity(e)
+
+	g, err = integration.Gauge(time.Now(), "windows_services_matched_count", 1)
+	require.NoError(t, err)
+	require.NoError(t, g.AddDimension("hostname", hostname))
+	i.HostEntity.AddMetric(g)
+
+	require.NoError(t, i.Publish())
+	return payload.Bytes()
+}
+
+func labels(m *dto.Metric) map[string]string {
+	l := make(map[string]string)
+	for _, lp := range m.GetLabel() {
+		l[lp.GetName()] = lp.GetValue()
+	}
+	return l
+}
+
+func TestServerServesLatestPayload(t *testing.T) {
+	s, err := New("127.0.0.1:0", "/metrics")
+	require.NoError(t, err)
+	defer s.Close()
+
+	get := func() string {
+		resp, err := http.Get("http://" + s.Addr() + "/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Empty(t, get(), "nothing is served before the first publication")
+
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "localhost"), testPayload(t, "localhost", 1)))
+	body := get()
+	assert.Contains(t, body, "# TYPE windows_service_state gauge")
+	assert.Contains(t, body, `windows_service_state{display_name="Remote Procedure Call (RPC)",entity_name="WIN_SERVICE:localhost:rpcss",hostname="localhost",service_name="rpcss",state="running",tags_team="core"} 1`)
+	assert.Contains(t, body, `windows_services_matched_count{hostname="localhost"} 1`)
+}
+
+func TestServerServesEveryHost(t *testing.T) {
+	s, err := New("127.0.0.1:0", "/metrics")
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "localhost"), testPayload(t, "localhost", 1)))
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "sql-01"), testPayload(t, "sql-01", 1)))
+	// a new payload of a host replaces only its previous one
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "localhost"), testPayload(t, "localhost", 0)))
+
+	resp, err := http.Get("http://" + s.Addr() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	families, err := new(expfmt.TextParser).TextToMetricFamilies(resp.Body)
+	require.NoError(t, err)
+
+	states := make(map[string]float64)
+	for _, m := range families["windows_service_state"].GetMetric() {
+		states[labels(m)["hostname"]] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{"localhost": 0, "sql-01": 1}, states)
+	assert.Len(t, families["windows_services_matched_count"].GetMetric(), 2)
+}
+
+func TestServerEmptyPayloadClearsHost(t *testing.T) {
+	s, err := New("127.0.0.1:0", "/metrics")
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "localhost"), testPayload(t, "localhost", 1)))
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "sql-01"), testPayload(t, "sql-01", 1)))
+	// no service of sql-01 matches anymore, the payload has no entity telling the host
+	var empty bytes.Buffer
+	i, err := integration.New("com.newrelic.winservices", "v1.0.0", integration.Writer(&empty))
+	require.NoError(t, err)
+	require.NoError(t, i.Publish())
+	require.NoError(t, s.Write(sink.WithHostname(context.Background(), "sql-01"), empty.Bytes()))
+
+	resp, err := http.Get("http://" + s.Addr() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `hostname="localhost"`)
+	assert.NotContains(t, string(body), `hostname="sql-01"`)
+}
+
+func TestServerInvalidPayload(t *testing.T) {
+	s, err := New("127.0.0.1:0", "/metrics")
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Error(t, s.Write(context.Background(), []byte("not json")))
+}
+
+func TestLabelName(t *testing.T) {
+	assert.Equal(t, "tags_team", labelName("tags.team"))
+	assert.Equal(t, "_1st", labelName("1st"))
+	assert.Equal(t, "service_name", labelName("service_name"))
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "windows_service_state", metricName("windows_service_state"))
+	assert.Equal(t, "windows_service_cpu_time", metricName("windows_service.cpu-time"))
+	assert.Equal(t, "job:rate_5m", metricName("job:rate_5m"))
+	assert.Equal(t, "_1st", metricName("1st"))
+}
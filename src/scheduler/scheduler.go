@@ -0,0 +1,125 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+)
+
+// Config defines when the scrape cycles are run
+type Config struct {
+	Interval time.Duration
+	// Jitter delays the first cycle by a random offset up to this fraction of the interval, from 0 to 1.
+	Jitter float64
+	// Align delays the first cycle, run after an interval, to a multiple of this duration of the wall
+	// clock, e.g. on the minute. Later cycles stay aligned when the interval is a multiple of it.
+	// It is not applied when 0.
+	Align time.Duration
+	// MaxSkipped is the number of consecutive ticks skipped after which the cycle in progress is
+	// considered stuck. It is not applied when 0.
+	MaxSkipped uint64
+}
+
+// ErrStalled is returned by Run when MaxSkipped consecutive ticks have been skipped
+var ErrStalled = errors.New("scrape cycle stalled")
+
+// Scheduler runs a cycle on every interval. A tick is skipped when the previous cycle is still running.
+type Scheduler struct {
+	config  Config
+	running int32
+	skipped uint64
+	// consecutive counts the ticks skipped since the last cycle started, only used by Run
+	consecutive uint64
+	cycles      sync.WaitGroup
+	// now and random are replaced by the tests
+	now    func() time.Time
+	random func() float64
+}
+
+// New creates a Scheduler
+func New(config Config) *Scheduler {
+	return &Scheduler{
+		config: config,
+		now:    time.Now,
+		random: rand.Float64,
+	}
+}
+
+// Run calls cycle on every tick until stop is closed. Cycles run in their own goroutine, so the
+// caller is not blocked by a slow one. Run returns once the cycle in progress, if any, has finished.
+// When MaxSkipped consecutive ticks are skipped Run returns ErrStalled without waiting for the
+// stuck cycle.
+func (s *Scheduler) Run(stop <-chan struct{}, cycle func()) error {
+	first := time.NewTimer(s.firstDelay())
+	defer first.Stop()
+	select {
+	case <-first.C:
+	case <-stop:
+		return nil
+	}
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	s.tick(cycle)
+	for {
+		select {
+		case <-ticker.C:
+			s.tick(cycle)
+			if s.config.MaxSkipped > 0 && s.consecutive >= s.config.MaxSkipped {
+				return fmt.Errorf("%w, %d consecutive ticks skipped", ErrStalled, s.consecutive)
+			}
+		case <-stop:
+			s.cycles.Wait()
+			return nil
+		}
+	}
+}
+
+// Skipped returns how many ticks have been skipped because the previous cycle was still running
+func (s *Scheduler) Skipped() uint64 {
+	return atomic.LoadUint64(&s.skipped)
+}
+
+func (s *Scheduler) tick(cycle func()) {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		skipped := atomic.AddUint64(&s.skipped, 1)
+		s.consecutive++
+		log.Warn("previous scrape cycle still running, tick skipped (%d skipped so far)", skipped)
+		return
+	}
+	s.consecutive = 0
+	s.cycles.Add(1)
+	go func() {
+		defer s.cycles.Done()
+		defer atomic.StoreInt32(&s.running, 0)
+		cycle()
+	}()
+}
+
+// firstDelay waits an interval, as a plain ticker does, extended to the next aligned instant if
+// alignment is configured, plus the random jitter.
+func (s *Scheduler) firstDelay() time.Duration {
+	delay := s.config.Interval
+	if s.config.Align > 0 {
+		now := s.now()
+		first := now.Add(s.config.Interval)
+		if aligned := first.Truncate(s.config.Align); !aligned.Equal(first) {
+			first = aligned.Add(s.config.Align)
+		}
+		delay = first.Sub(now)
+	}
+	if s.config.Jitter > 0 {
+		delay += time.Duration(s.random() * s.config.Jitter * float64(s.config.Interval))
+	}
+	return delay
+}
@@ -0,0 +1,138 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstDelay(t *testing.T) {
+	now := time.Date(2020, 5, 4, 10, 30, 20, 0, time.UTC)
+
+	tests := map[string]struct {
+		config   Config
+		random   float64
+		expected time.Duration
+	}{
+		"plain interval":        {Config{Interval: 30 * time.Second}, 0.5, 30 * time.Second},
+		"jitter":                {Config{Interval: 30 * time.Second, Jitter: 0.2}, 0.5, 33 * time.Second},
+		"max jitter":            {Config{Interval: 30 * time.Second, Jitter: 1}, 1, 60 * time.Second},
+		"aligned on the minute": {Config{Interval: 30 * time.Second, Align: time.Minute}, 0, 40 * time.Second},
+		"already aligned":       {Config{Interval: 40 * time.Second, Align: time.Minute}, 0, 40 * time.Second},
+		"aligned with jitter":   {Config{Interval: 60 * time.Second, Align: time.Minute, Jitter: 0.1}, 0.5, 100*time.Second + 3*time.Second},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(tt.config)
+			s.now = func() time.Time { return now }
+			s.random = func() float64 { return tt.random }
+			assert.Equal(t, tt.expected, s.firstDelay())
+		})
+	}
+}
+
+func TestRunSkipsTicksWhileCycleIsRunning(t *testing.T) {
+	s := New(Config{Interval: 10 * time.Millisecond})
+	stop := make(chan struct{})
+	release := make(chan struct{})
+	cycles := make(chan struct{}, 10)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop, func() {
+			cycles <- struct{}{}
+			<-release
+		})
+		close(done)
+	}()
+
+	<-cycles
+	// the first cycle blocks until released so the following ticks are skipped
+	assert.Eventually(t, func() bool { return s.Skipped() >= 3 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, cycles, 0)
+
+	close(release)
+	<-cycles
+	close(stop)
+	<-done
+}
+
+func TestRunStopsBeforeFirstCycle(t *testing.T) {
+	s := New(Config{Interval: time.Hour})
+	stop := make(chan struct{})
+	close(stop)
+
+	s.Run(stop, func() { t.Fatal("no cycle is expected") })
+	assert.Equal(t, uint64(0), s.Skipped())
+}
+
+func TestRunWaitsForCycleInProgress(t *testing.T) {
+	s := New(Config{Interval: 10 * time.Millisecond})
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	var finished int32
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop, func() {
+			once.Do(func() { close(started) })
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		})
+		close(done)
+	}()
+
+	<-started
+	close(stop)
+	<-done
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+}
+
+func TestRunReturnsWhenCycleIsStalled(t *testing.T) {
+	s := New(Config{Interval: 10 * time.Millisecond, MaxSkipped: 3})
+	release := make(chan struct{})
+	defer close(release)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run(make(chan struct{}), func() { <-release })
+	}()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrStalled)
+		assert.Equal(t, uint64(3), s.Skipped())
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return while the cycle was stuck")
+	}
+}
+
+func TestRunResetsConsecutiveSkips(t *testing.T) {
+	s := New(Config{Interval: 10 * time.Millisecond, MaxSkipped: 3})
+	stop := make(chan struct{})
+	var cycles int32
+
+	done := make(chan error)
+	go func() {
+		// every cycle makes a couple of ticks be skipped, never MaxSkipped in a row
+		done <- s.Run(stop, func() {
+			atomic.AddInt32(&cycles, 1)
+			time.Sleep(15 * time.Millisecond)
+		})
+	}()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cycles) >= 3 }, time.Second, 5*time.Millisecond)
+	close(stop)
+	assert.NoError(t, <-done)
+	assert.Greater(t, s.Skipped(), uint64(0))
+}
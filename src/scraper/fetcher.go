@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,6 +6,7 @@
 package scraper
 
 import (
+	"context"
 	"fmt"
 	"github.com/newrelic/infra-integrations-sdk/v4/log"
 	dto "github.com/prometheus/client_model/go"
@@ -19,12 +17,12 @@ import (
 	"github.com/prometheus/common/expfmt"
 )
 
-type MetricFamiliesByName map[string]dto.MetricFamily
+type MetricFamiliesByName map[string]*dto.MetricFamily
 
-// Get scrapes the given URL and decodes the retrieved payload.
-func Get(client HTTPDoer, url string) (MetricFamiliesByName, error) {
+// Get scrapes the given URL and decodes the retrieved payload. The request is aborted when ctx is cancelled.
+func Get(ctx context.Context, client HTTPDoer, url string) (MetricFamiliesByName, error) {
 	mfs := MetricFamiliesByName{}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return mfs, err
 	}
@@ -35,21 +33,31 @@ func Get(client HTTPDoer, url string) (MetricFamiliesByName, error) {
 	if err != nil {
 		return mfs, err
 	}
-	if resp.StatusCode != 200 {
-		return mfs, fmt.Errorf("the exporter answered with a value different from 200")
-	}
-	log.Debug("HTTP request performed - Status: %s, total time taken to perform request: %s", resp.Status, time.Since(t).String())
-
 	defer func() {
 		_ = resp.Body.Close()
 	}()
+	if resp.StatusCode != 200 {
+		return mfs, fmt.Errorf("the exporter answered with a value different from 200: %s", resp.Status)
+	}
+	log.Debug("HTTP request performed - Status: %s, total time taken to perform request: %s", resp.Status, time.Since(t).String())
 
 	log.Debug("Parsing body of the exporter answer")
 	countedBody := &countReadCloser{innerReadCloser: resp.Body}
-	d := expfmt.NewDecoder(countedBody, expfmt.NewFormat(expfmt.TypeTextPlain))
+	mfs, err = Parse(countedBody)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Body of the exporter answer parsed")
+	return mfs, nil
+}
+
+// Parse decodes metrics in the Prometheus text format, e.g. an exporter output saved to a file.
+func Parse(r io.Reader) (MetricFamiliesByName, error) {
+	mfs := MetricFamiliesByName{}
+	d := expfmt.NewDecoder(r, expfmt.NewFormat(expfmt.TypeTextPlain))
 	for {
-		var mf dto.MetricFamily
-		if err = d.Decode(&mf); err != nil {
+		mf := &dto.MetricFamily{}
+		if err := d.Decode(mf); err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -57,7 +65,6 @@ func Get(client HTTPDoer, url string) (MetricFamiliesByName, error) {
 		}
 		mfs[mf.GetName()] = mf
 	}
-	log.Debug("Body of the exporter answer parsed")
 	return mfs, nil
 }
 
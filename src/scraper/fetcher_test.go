@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,11 +6,15 @@
 package scraper
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetReal(t *testing.T) {
@@ -21,10 +22,82 @@ func TestGetReal(t *testing.T) {
 		http.ServeFile(w, r, "testdata/actualOutput")
 	}))
 	defer ts.Close()
-	mfs, err := Get(http.DefaultClient, ts.URL)
+	mfs, err := Get(context.Background(), http.DefaultClient, ts.URL)
 	var actual []string
 	for k := range mfs {
 		actual = append(actual, k)
 	}
 	assert.NoError(t, err)
 }
+
+func TestGetCancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Get(ctx, http.DefaultClient, ts.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGetScriptedExporter(t *testing.T) {
+	spooler := exportertest.Service{Name: "spooler", DisplayName: "Print Spooler", ProcessID: 1234}
+	stoppedSpooler := spooler
+	stoppedSpooler.State = "stopped"
+
+	s := exportertest.New(
+		exportertest.OK(spooler),
+		exportertest.Error(http.StatusInternalServerError),
+		exportertest.Malformed(),
+		exportertest.OK(stoppedSpooler),
+	)
+	defer s.Close()
+
+	mfs, err := Get(context.Background(), http.DefaultClient, s.MetricsURL())
+	require.NoError(t, err)
+	assert.Equal(t, "running", activeState(t, mfs))
+
+	_, err = Get(context.Background(), http.DefaultClient, s.MetricsURL())
+	assert.EqualError(t, err, "the exporter answered with a value different from 200: 500 Internal Server Error")
+
+	_, err = Get(context.Background(), http.DefaultClient, s.MetricsURL())
+	assert.Error(t, err)
+
+	// the last response is repeated
+	for i := 0; i < 2; i++ {
+		mfs, err = Get(context.Background(), http.DefaultClient, s.MetricsURL())
+		require.NoError(t, err)
+		assert.Equal(t, "stopped", activeState(t, mfs))
+	}
+	assert.Equal(t, 5, s.Requests())
+}
+
+func TestGetSlowExporterTimeout(t *testing.T) {
+	s := exportertest.New(exportertest.Slow(time.Minute, exportertest.Service{Name: "spooler"}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := Get(ctx, http.DefaultClient, s.MetricsURL())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// activeState returns the state reported with value 1 for the only service in mfs
+func activeState(t *testing.T, mfs MetricFamiliesByName) string {
+	mf, ok := mfs["windows_service_state"]
+	require.True(t, ok)
+	for _, m := range mf.GetMetric() {
+		if m.GetGauge().GetValue() != 1 {
+			continue
+		}
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "state" {
+				return l.GetValue()
+			}
+		}
+	}
+	t.Fatal("no active state")
+	return ""
+}
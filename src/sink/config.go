@@ -0,0 +1,76 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// TypeStdout writes to stdout to be read by the Agent
+	TypeStdout = "stdout"
+	// TypeFile appends JSON lines to a rotating file
+	TypeFile = "file"
+	// TypeHTTP posts the payload to an URL
+	TypeHTTP = "http"
+)
+
+// Config holds the configuration of a sink
+type Config struct {
+	Type string
+	// file sink
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	// http sink
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+
+	Policy Policy
+}
+
+// Validate checks that the fields required by the sink type are set
+func (c Config) Validate() error {
+	if c.Policy.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+	switch c.Type {
+	case TypeStdout:
+		// a write interrupted midway can't be taken back, retrying it would corrupt the JSON lines
+		if c.Policy.Retries > 0 {
+			return fmt.Errorf("retries are not supported for %s sink", c.Type)
+		}
+	case TypeFile:
+		if c.Path == "" {
+			return fmt.Errorf("path needs to be configured for %s sink", c.Type)
+		}
+	case TypeHTTP:
+		if c.URL == "" {
+			return fmt.Errorf("url needs to be configured for %s sink", c.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported sink type: %q", c.Type)
+	}
+	return nil
+}
+
+// New creates the sink described by the config
+func New(c Config) (Sink, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case TypeFile:
+		return NewFile(c.Path, c.MaxSize, c.MaxBackups)
+	case TypeHTTP:
+		return NewHTTP(c.URL, c.Headers, c.Timeout), nil
+	default:
+		return NewStdout(os.Stdout), nil
+	}
+}
@@ -0,0 +1,111 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File appends each payload as a JSON line to a file. When the file would exceed maxSize
+// it is rotated to <path>.1, shifting older files up to <path>.<maxBackups>.
+type File struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFile creates a File sink. A maxSize of 0 disables the rotation.
+func NewFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Name identifies the sink in logs
+func (f *File) Name() string {
+	return TypeFile + ":" + f.path
+}
+
+// Write appends the payload compacted to a single line
+func (f *File) Write(_ context.Context, payload []byte) error {
+	var line bytes.Buffer
+	if err := json.Compact(&line, payload); err != nil {
+		return fmt.Errorf("failed to compact payload:%v", err)
+	}
+	line.WriteByte('\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(line.Len()) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+// Close closes the current file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open sink file:%v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat sink file:%v", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close sink file:%v", err)
+	}
+
+	if f.maxBackups > 0 {
+		_ = os.Remove(backupName(f.path, f.maxBackups))
+		for n := f.maxBackups - 1; n > 0; n-- {
+			_ = os.Rename(backupName(f.path, n), backupName(f.path, n+1))
+		}
+		if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate sink file:%v", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to rotate sink file:%v", err)
+	}
+
+	return f.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
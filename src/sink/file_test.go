@@ -0,0 +1,55 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payloads.jsonl")
+	f, err := NewFile(path, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, f.Write(context.Background(), []byte("{\n\t\"data\": []\n}\n")))
+	require.NoError(t, f.Write(context.Background(), []byte(`{"data":[1]}`)))
+	require.NoError(t, f.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"data\":[]}\n{\"data\":[1]}\n", string(content))
+
+	assert.Error(t, f.Write(context.Background(), []byte("not json")))
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payloads.jsonl")
+	line := []byte(`{"n":0}`) // 8 bytes with the new line
+	f, err := NewFile(path, 16, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for n := 0; n < 7; n++ {
+		line[5] = byte('0' + n)
+		require.NoError(t, f.Write(context.Background(), line))
+	}
+
+	read := func(p string) string {
+		content, err := os.ReadFile(p)
+		require.NoError(t, err)
+		return string(content)
+	}
+	assert.Equal(t, "{\"n\":6}\n", read(path))
+	assert.Equal(t, "{\"n\":4}\n{\"n\":5}\n", read(path+".1"))
+	assert.Equal(t, "{\"n\":2}\n{\"n\":3}\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
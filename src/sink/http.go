@@ -0,0 +1,68 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTP posts the payload to an URL
+type HTTP struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewHTTP creates an HTTP sink
+func NewHTTP(url string, headers map[string]string, timeout time.Duration) *HTTP {
+	return &HTTP{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Name identifies the sink in logs
+func (h *HTTP) Name() string {
+	return TypeHTTP + ":" + h.url
+}
+
+// Write posts the payload as JSON, any answer out of the 2xx range is an error
+func (h *HTTP) Write(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the endpoint answered with status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
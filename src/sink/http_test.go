@@ -0,0 +1,46 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPPostsPayload(t *testing.T) {
+	var body, token string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		token = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	h := NewHTTP(ts.URL, map[string]string{"Authorization": "Bearer token"}, time.Second)
+	defer h.Close()
+
+	require.NoError(t, h.Write(context.Background(), []byte(`{"data":[]}`)))
+	assert.Equal(t, `{"data":[]}`, body)
+	assert.Equal(t, "Bearer token", token)
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	h := NewHTTP(ts.URL, nil, time.Second)
+	err := h.Write(context.Background(), []byte(`{}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+)
+
+// Sink receives the payload published by the integration on every scrape
+type Sink interface {
+	// Name identifies the sink in logs
+	Name() string
+	// Write sends the payload, it must not retain it after returning
+	Write(ctx context.Context, payload []byte) error
+	// Close releases the resources held by the sink
+	Close() error
+}
+
+// Policy defines how the Publisher handles the failures of a sink
+type Policy struct {
+	Retries    int
+	RetryDelay time.Duration
+	// Required makes the publication fail when the sink fails, otherwise the failure is only logged
+	Required bool
+}
+
+// queueSize is the number of payloads waiting to be written to an optional sink, the newer ones
+// are dropped while it is full
+const queueSize = 4
+
+// flushTimeout is the time Close gives the optional sinks to write the payloads still queued, then
+// their writes are cancelled
+const flushTimeout = 10 * time.Second
+
+// publication is a payload queued for an optional sink with the context it has been published with.
+// The context is detached from the cancellation of the publication, which usually happens on
+// shutdown before the queue is drained, so only its values are kept.
+type publication struct {
+	ctx     context.Context
+	payload []byte
+}
+
+type entry struct {
+	sink   Sink
+	policy Policy
+	// mu serializes the writes of a required sink, which are done by the publications
+	mu sync.Mutex
+	// queue holds the payloads of an optional sink, written in the background
+	queue chan publication
+}
+
+// hostnameKey is the context key of the host the payload has been collected from
+type hostnameKey struct{}
+
+// WithHostname returns a copy of ctx telling the sinks the host the payload has been collected from
+func WithHostname(ctx context.Context, hostname string) context.Context {
+	return context.WithValue(ctx, hostnameKey{}, hostname)
+}
+
+// Hostname returns the host the payload written with ctx has been collected from, empty when unknown
+func Hostname(ctx context.Context) string {
+	hostname, _ := ctx.Value(hostnameKey{}).(string)
+	return hostname
+}
+
+// Publisher sends the payload to all the sinks. The required sinks are written concurrently and
+// waited for, so their failures are returned. Each optional sink has its own queue written in the
+// background, a slow optional sink drops payloads instead of delaying the publications.
+//
+// It implements io.Writer so it can be used as the integration writer: the payloads serialized by
+// integration.Publish are kept until Take returns them to be published.
+type Publisher struct {
+	mu      sync.Mutex
+	entries []*entry
+	pending []byte
+	workers sync.WaitGroup
+	// flushed is cancelled when the optional sinks take longer than flushTimeout to drain their
+	// queues on Close
+	flushed      context.Context
+	cancelFlush  context.CancelFunc
+	flushTimeout time.Duration
+}
+
+// NewPublisher creates a Publisher with no sinks
+func NewPublisher() *Publisher {
+	flushed, cancelFlush := context.WithCancel(context.Background())
+	return &Publisher{flushed: flushed, cancelFlush: cancelFlush, flushTimeout: flushTimeout}
+}
+
+// Add registers a sink with its failure policy
+func (p *Publisher) Add(s Sink, policy Policy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := &entry{sink: s, policy: policy}
+	if !policy.Required {
+		e.queue = make(chan publication, queueSize)
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for pub := range e.queue {
+				ctx, cancel := context.WithCancel(pub.ctx)
+				stop := context.AfterFunc(p.flushed, cancel)
+				if err := write(ctx, e, pub.payload); err != nil {
+					log.Error("failed to publish to sink %s:%v", s.Name(), err)
+				}
+				stop()
+				cancel()
+			}
+		}()
+	}
+	p.entries = append(p.entries, e)
+}
+
+// Write keeps the payload until it is returned by Take
+func (p *Publisher) Write(payload []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, payload...)
+	return len(payload), nil
+}
+
+// Take returns the payloads written since the previous call
+func (p *Publisher) Take() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payload := p.pending
+	p.pending = nil
+	return payload
+}
+
+// Publish sends the payload to all the sinks, it must not be modified afterwards. The writes to the
+// required sinks and their retries are aborted once ctx is cancelled, the payloads queued for the
+// optional ones are written until Close gives up on them. The payload doesn't identify the host when it
+// has no entities, so the caller sets it in ctx with WithHostname. Only the failures of required
+// sinks are returned.
+func (p *Publisher) Publish(ctx context.Context, payload []byte) error {
+	p.mu.Lock()
+	entries := p.entries
+	p.mu.Unlock()
+
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for idx, e := range entries {
+		if !e.policy.Required {
+			select {
+			case e.queue <- publication{ctx: context.WithoutCancel(ctx), payload: payload}:
+			default:
+				log.Warn("sink %s is not keeping up, dropping the payload", e.sink.Name())
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, e *entry) {
+			defer wg.Done()
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			errs[idx] = write(ctx, e, payload)
+		}(idx, e)
+	}
+	wg.Wait()
+
+	var failed []string
+	for idx, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", entries[idx].sink.Name(), err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("required sinks failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func write(ctx context.Context, e *entry, payload []byte) error {
+	var err error
+	for attempt := 0; attempt <= e.policy.Retries; attempt++ {
+		if attempt > 0 {
+			log.Debug("retrying sink %s, attempt %d: %v", e.sink.Name(), attempt, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(e.policy.RetryDelay):
+			}
+		}
+		if err = e.sink.Write(ctx, payload); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Close waits for the payloads queued for the optional sinks to be written and closes all the sinks.
+// The writes still in progress after flushTimeout are cancelled. Nothing can be published afterwards.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.entries {
+		if e.queue != nil {
+			close(e.queue)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	timeout := time.NewTimer(p.flushTimeout)
+	defer timeout.Stop()
+	select {
+	case <-done:
+	case <-timeout.C:
+		log.Warn("the optional sinks didn't write the queued payloads in %s, cancelling them", p.flushTimeout)
+		p.cancelFlush()
+		<-done
+	}
+	p.cancelFlush()
+	for _, e := range p.entries {
+		if err := e.sink.Close(); err != nil {
+			log.Warn("failed to close sink %s:%v", e.sink.Name(), err)
+		}
+	}
+}
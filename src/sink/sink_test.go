@@ -0,0 +1,202 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSink fails the first failures writes and records the successful ones
+type fakeSink struct {
+	name      string
+	failures  int
+	calls     int
+	written   [][]byte
+	hostnames []string
+	closed    bool
+}
+
+func (f *fakeSink) Name() string { return f.name }
+
+func (f *fakeSink) Write(ctx context.Context, payload []byte) error {
+	f.calls++
+	f.hostnames = append(f.hostnames, Hostname(ctx))
+	if f.calls <= f.failures {
+		return fmt.Errorf("failure %d", f.calls)
+	}
+	f.written = append(f.written, append([]byte(nil), payload...))
+	return nil
+}
+
+func (f *fakeSink) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPublisherWritesToAllSinks(t *testing.T) {
+	var stdout bytes.Buffer
+	fake := &fakeSink{name: "fake"}
+
+	p := NewPublisher()
+	p.Add(NewStdout(&stdout), Policy{Required: true})
+	p.Add(fake, Policy{})
+
+	i, err := integration.New("integrationName", "integrationVersion", integration.Writer(p))
+	require.NoError(t, err)
+	require.NoError(t, i.Publish())
+	payload := p.Take()
+	require.NoError(t, p.Publish(context.Background(), payload))
+	assert.Empty(t, p.Take())
+
+	assert.Contains(t, stdout.String(), `"integration":{"name":"integrationName"`)
+	// the optional sink is written in the background, Close waits for it
+	p.Close()
+	require.Len(t, fake.written, 1)
+	assert.Equal(t, stdout.Bytes(), fake.written[0])
+	assert.True(t, fake.closed)
+}
+
+func TestPublisherFailurePolicy(t *testing.T) {
+	optional := &fakeSink{name: "optional", failures: 5}
+	retried := &fakeSink{name: "retried", failures: 2}
+	required := &fakeSink{name: "required", failures: 1}
+
+	p := NewPublisher()
+	p.Add(optional, Policy{})
+	p.Add(retried, Policy{Retries: 2, Required: true})
+	p.Add(required, Policy{Required: true})
+
+	err := p.Publish(context.Background(), []byte("{}"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required: failure 1")
+	assert.NotContains(t, err.Error(), "optional")
+	assert.NotContains(t, err.Error(), "retried")
+	assert.Equal(t, 3, retried.calls)
+	assert.Len(t, retried.written, 1)
+
+	// the required sink only failed once
+	require.NoError(t, p.Publish(context.Background(), []byte("{}")))
+
+	p.Close()
+	assert.Equal(t, 2, optional.calls)
+}
+
+func TestPublisherHostname(t *testing.T) {
+	fake := &fakeSink{name: "fake"}
+	p := NewPublisher()
+	p.Add(fake, Policy{Required: true})
+
+	require.NoError(t, p.Publish(context.Background(), []byte("{}")))
+	require.NoError(t, p.Publish(WithHostname(context.Background(), "sql-01"), []byte("{}")))
+
+	assert.Equal(t, []string{"", "sql-01"}, fake.hostnames)
+}
+
+func TestPublisherCancelledRetries(t *testing.T) {
+	failing := &fakeSink{name: "failing", failures: 5}
+	p := NewPublisher()
+	p.Add(failing, Policy{Retries: 4, RetryDelay: time.Hour, Required: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.Publish(ctx, []byte("{}"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	// the retry delay is not waited once the context is cancelled
+	assert.Equal(t, 1, failing.calls)
+}
+
+// blockedSink blocks the writes until release is closed, started receives the first write
+type blockedSink struct {
+	fakeSink
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockedSink) Write(ctx context.Context, payload []byte) error {
+	if b.calls == 0 {
+		close(b.started)
+	}
+	<-b.release
+	return b.fakeSink.Write(ctx, payload)
+}
+
+func TestPublisherDropsPayloadsOfSlowOptionalSink(t *testing.T) {
+	slow := &blockedSink{fakeSink: fakeSink{name: "slow"}, started: make(chan struct{}), release: make(chan struct{})}
+	p := NewPublisher()
+	p.Add(slow, Policy{})
+
+	require.NoError(t, p.Publish(context.Background(), []byte("0")))
+	<-slow.started
+	// the publications don't wait for the optional sink, once its queue is full the payloads are dropped
+	for n := 1; n <= queueSize+2; n++ {
+		require.NoError(t, p.Publish(context.Background(), []byte(fmt.Sprint(n))))
+	}
+	close(slow.release)
+	p.Close()
+
+	require.Len(t, slow.written, queueSize+1)
+	assert.Equal(t, []byte("0"), slow.written[0])
+	assert.Equal(t, []byte(fmt.Sprint(queueSize)), slow.written[queueSize])
+}
+
+func TestPublisherFlushesOptionalSinksAfterCancel(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, string(b))
+	}))
+	defer ts.Close()
+
+	p := NewPublisher()
+	p.Add(NewHTTP(ts.URL, nil, time.Second), Policy{})
+
+	// the context is cancelled on shutdown before the publisher is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, p.Publish(ctx, []byte(`{"data":[]}`)))
+	cancel()
+	p.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{`{"data":[]}`}, received)
+}
+
+// stuckSink blocks the writes until their context is cancelled
+type stuckSink struct {
+	fakeSink
+}
+
+func (s *stuckSink) Write(ctx context.Context, payload []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPublisherCloseCancelsStuckOptionalSinks(t *testing.T) {
+	stuck := &stuckSink{fakeSink: fakeSink{name: "stuck"}}
+	p := NewPublisher()
+	p.flushTimeout = 10 * time.Millisecond
+	p.Add(stuck, Policy{})
+
+	require.NoError(t, p.Publish(context.Background(), []byte("{}")))
+	p.Close()
+	assert.True(t, stuck.closed)
+}
@@ -0,0 +1,37 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sink
+
+import (
+	"context"
+	"io"
+)
+
+// Stdout writes the payload to the integration stdout, which is read by the Agent
+type Stdout struct {
+	w io.Writer
+}
+
+// NewStdout creates a Stdout sink writing to w
+func NewStdout(w io.Writer) *Stdout {
+	return &Stdout{w: w}
+}
+
+// Name identifies the sink in logs
+func (s *Stdout) Name() string {
+	return TypeStdout
+}
+
+// Write writes the payload as it is
+func (s *Stdout) Write(_ context.Context, payload []byte) error {
+	_, err := s.w.Write(payload)
+	return err
+}
+
+// Close does nothing since stdout is not owned by the sink
+func (s *Stdout) Close() error {
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build windows && amd64
+// +build windows,amd64
+
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/newrelic/nri-winservices/src/exporter"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// Exporter scrapes the windows_exporter spawned by the integration
+type Exporter struct {
+	exporter *exporter.Exporter
+}
+
+// NewExporter creates a source spawning the exporter when started
+func NewExporter(e *exporter.Exporter) *Exporter {
+	return &Exporter{exporter: e}
+}
+
+// Start runs the exporter
+func (e *Exporter) Start() error {
+	return e.exporter.Run()
+}
+
+// Fetch scrapes the exporter
+func (e *Exporter) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	return scrape(ctx, http.DefaultClient, "http://"+e.exporter.URL+e.exporter.MetricPath)
+}
+
+// Done is closed when the exporter stops running
+func (e *Exporter) Done() <-chan struct{} {
+	return e.exporter.Done
+}
+
+// Stop kills the exporter
+func (e *Exporter) Stop() {
+	e.exporter.Kill()
+}
@@ -0,0 +1,69 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// FileReplay replays exporter outputs saved to files, e.g. to reproduce an issue from a customer dump.
+// Each Fetch returns the next file, the last one is repeated once all have been replayed.
+type FileReplay struct {
+	lock  sync.Mutex
+	paths []string
+	next  int
+}
+
+// NewFileReplay creates a source replaying the given files in order
+func NewFileReplay(paths ...string) *FileReplay {
+	return &FileReplay{paths: paths}
+}
+
+// Start checks that there is something to replay
+func (f *FileReplay) Start() error {
+	if len(f.paths) == 0 {
+		return fmt.Errorf("no file to replay")
+	}
+	return nil
+}
+
+// Fetch parses the next file
+func (f *FileReplay) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	path := f.paths[f.next]
+	if f.next < len(f.paths)-1 {
+		f.next++
+	}
+	f.lock.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to replay metrics:%v", err)
+	}
+	defer file.Close()
+
+	metricsByFamily, err := scraper.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("fail to replay metrics from %s:%v", path, err)
+	}
+	return metricsByFamily, nil
+}
+
+// Done returns nil, the files are always available
+func (f *FileReplay) Done() <-chan struct{} {
+	return nil
+}
+
+// Stop does nothing
+func (f *FileReplay) Stop() {}
@@ -0,0 +1,72 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDump(t *testing.T, content string) string {
+	tmpfile, err := ioutil.TempFile("", "dump")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	_, err = tmpfile.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, tmpfile.Close())
+	return tmpfile.Name()
+}
+
+func TestFileReplay(t *testing.T) {
+	stopped := spooler
+	stopped.State = "stopped"
+	f := NewFileReplay(
+		writeDump(t, exportertest.Metrics(spooler)),
+		writeDump(t, exportertest.Metrics(stopped)),
+	)
+	require.NoError(t, f.Start())
+	defer f.Stop()
+	assert.Nil(t, f.Done())
+
+	// the last file is repeated once all have been replayed
+	for _, expected := range []string{"running", "stopped", "stopped"} {
+		mfbn, err := f.Fetch(context.Background())
+		require.NoError(t, err)
+		var state string
+		for _, m := range mfbn["windows_service_state"].Metric {
+			if m.GetGauge().GetValue() == 1 {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "state" {
+						state = l.GetValue()
+					}
+				}
+			}
+		}
+		assert.Equal(t, expected, state)
+	}
+}
+
+func TestFileReplayErrors(t *testing.T) {
+	assert.Error(t, NewFileReplay().Start())
+
+	_, err := NewFileReplay("not-existing.prom").Fetch(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewFileReplay(writeDump(t, "malformed{")).Fetch(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fail to replay metrics")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewFileReplay(writeDump(t, exportertest.Metrics(spooler))).Fetch(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,152 @@
+//go:build windows && amd64
+// +build windows,amd64
+
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// SCM reads the services from the Service Control Manager, without spawning the exporter.
+// The handles are opened with read only access rights, instead of the full access used by mgr.
+type SCM struct {
+	lock    sync.Mutex
+	manager *mgr.Mgr
+}
+
+// NewSCM creates a source querying the Service Control Manager of the host
+func NewSCM() *SCM {
+	return &SCM{}
+}
+
+// Start connects to the Service Control Manager
+func (s *SCM) Start() error {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT|windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager:%v", err)
+	}
+	s.lock.Lock()
+	s.manager = &mgr.Mgr{Handle: h}
+	s.lock.Unlock()
+	return nil
+}
+
+// Fetch queries the status and the config of every service
+func (s *SCM) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.manager == nil {
+		return nil, fmt.Errorf("not connected to the service control manager")
+	}
+
+	t := time.Now()
+	names, err := s.manager.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services:%v", err)
+	}
+
+	services := make([]scmService, 0, len(names))
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		service, err := s.queryService(name)
+		if err != nil {
+			// services can be deleted after being listed
+			log.Debug("skipping service %s: %v", name, err)
+			continue
+		}
+		services = append(services, service)
+	}
+	log.Debug("Services queried, found: %d, time elapsed: %s", len(services), time.Since(t).String())
+	return scmFamilies(services), nil
+}
+
+func (s *SCM) queryService(name string) (scmService, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return scmService{}, err
+	}
+	h, err := windows.OpenService(s.manager.Handle, namePtr, windows.SERVICE_QUERY_CONFIG|windows.SERVICE_QUERY_STATUS)
+	if err != nil {
+		return scmService{}, fmt.Errorf("failed to open service:%v", err)
+	}
+	handle := &mgr.Service{Name: name, Handle: h}
+	defer handle.Close()
+
+	config, err := handle.Config()
+	if err != nil {
+		return scmService{}, fmt.Errorf("failed to query service config:%v", err)
+	}
+	status, err := handle.Query()
+	if err != nil {
+		return scmService{}, fmt.Errorf("failed to query service status:%v", err)
+	}
+
+	service := scmService{
+		Name:        name,
+		DisplayName: config.DisplayName,
+		RunAs:       config.ServiceStartName,
+		State:       uint32(status.State),
+		StartType:   config.StartType,
+		ProcessID:   status.ProcessId,
+		ImagePath:   config.BinaryPathName,
+		// descriptions can be indirect strings, like @%SystemRoot%\system32\spoolsv.exe,-2, that are not resolved
+		Description:      config.Description,
+		ServiceType:      config.ServiceType,
+		DelayedAutoStart: config.DelayedAutoStart,
+		SidType:          config.SidType,
+		// dependencies are reported with the case used when they were configured
+		Dependencies: config.Dependencies,
+	}
+
+	// the service is still reported when its failure actions can't be read
+	if err = queryFailureActions(handle, &service); err != nil {
+		log.Debug("failure actions of service %s not available: %v", name, err)
+	}
+	return service, nil
+}
+
+// queryFailureActions sets the failure actions and the reset period of the service
+func queryFailureActions(handle *mgr.Service, service *scmService) error {
+	actions, err := handle.RecoveryActions()
+	if err != nil {
+		return err
+	}
+	if service.ResetPeriod, err = handle.ResetPeriod(); err != nil {
+		return err
+	}
+	for _, a := range actions {
+		service.FailureActions = append(service.FailureActions, scmFailureAction{Type: uint32(a.Type), Delay: a.Delay})
+	}
+	service.FailureActionsKnown = true
+	return nil
+}
+
+// Done returns nil, the Service Control Manager is always running
+func (s *SCM) Done() <-chan struct{} {
+	return nil
+}
+
+// Stop disconnects from the Service Control Manager
+func (s *SCM) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.manager != nil {
+		_ = s.manager.Disconnect()
+		s.manager = nil
+	}
+}
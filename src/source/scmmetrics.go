@@ -0,0 +1,232 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// scmService holds what the Service Control Manager reports for a service. State, StartType,
+// ServiceType and SidType are the codes of the Win32 API.
+type scmService struct {
+	Name             string
+	DisplayName      string
+	RunAs            string
+	State            uint32
+	StartType        uint32
+	ProcessID        uint32
+	ImagePath        string
+	Description      string
+	ServiceType      uint32
+	DelayedAutoStart bool
+	SidType          uint32
+	// Dependencies lists the services and the load order groups, prefixed by '+', the service depends on
+	Dependencies []string
+	// FailureActionsKnown is false when the failure actions couldn't be queried
+	FailureActionsKnown bool
+	// FailureActions are the actions taken on the first, second and following failures, the last
+	// one is repeated for the later failures
+	FailureActions []scmFailureAction
+	// ResetPeriod is the time, in seconds, without failures after which the failure count is reset
+	ResetPeriod uint32
+}
+
+// scmFailureAction is a SC_ACTION, the action taken by the SCM when the service fails
+type scmFailureAction struct {
+	Type  uint32
+	Delay time.Duration
+}
+
+// scmStates maps the SERVICE_STATUS states to the values reported by the exporter
+var scmStates = map[uint32]string{
+	1: "stopped",
+	2: "start pending",
+	3: "stop pending",
+	4: "running",
+	5: "continue pending",
+	6: "pause pending",
+	7: "paused",
+}
+
+// scmStartModes maps the service start types to the values reported by the exporter
+var scmStartModes = map[uint32]string{
+	0: "boot",
+	1: "system",
+	2: "auto",
+	3: "manual",
+	4: "disabled",
+}
+
+// scmActionTypes maps the SC_ACTION types to the values reported
+var scmActionTypes = map[uint32]string{
+	0: "none",
+	1: "restart",
+	2: "reboot",
+	3: "run_command",
+}
+
+// infiniteResetPeriod is the INFINITE value of the Win32 API, the failure count is never reset
+const infiniteResetPeriod = 0xFFFFFFFF
+
+// Bits of the service type
+const (
+	serviceKernelDriver     = 0x1
+	serviceFileSystemDriver = 0x2
+	serviceOwnProcess       = 0x10
+	serviceShareProcess     = 0x20
+	serviceUserService      = 0x40
+)
+
+// scmSidTypes maps the service SID types to the values reported
+var scmSidTypes = map[uint32]string{
+	0: "none",
+	1: "unrestricted",
+	3: "restricted",
+}
+
+// the enum values in the order the exporter reports them
+var (
+	exporterStates     = []string{"continue pending", "pause pending", "paused", "running", "start pending", "stop pending", "stopped", "unknown"}
+	exporterStartModes = []string{"auto", "boot", "disabled", "manual", "system"}
+)
+
+const (
+	unknownState = "unknown"
+	// groupPrefix marks the dependencies on a load order group instead of a service
+	groupPrefix = "+"
+)
+
+// scmFamilies translates the services into the metric families the exporter serves, so they are
+// processed the same way. Services are reported sorted by name. The data not available from the
+// exporter is reported in windows_service_config, windows_service_dependency and the failure
+// actions families.
+func scmFamilies(services []scmService) map[string]*dto.MetricFamily {
+	sorted := make([]scmService, len(services))
+	copy(sorted, services)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Name < sorted[b].Name })
+
+	var info, process, startMode, state, config, dependency, failureAction, resetPeriod []*dto.Metric
+	for _, s := range sorted {
+		info = append(info, gaugeMetric(1, "display_name", s.DisplayName, "name", s.Name, "run_as", s.RunAs))
+		if s.ProcessID != 0 {
+			process = append(process, gaugeMetric(1, "name", s.Name, "process_id", strconv.FormatUint(uint64(s.ProcessID), 10)))
+		}
+		// start types not known by the exporter have no active value
+		startMode = append(startMode, enumMetrics(s.Name, "start_mode", exporterStartModes, scmStartModes[s.StartType])...)
+
+		state = append(state, enumMetrics(s.Name, "state", exporterStates, valueOrUnknown(scmStates, s.State))...)
+
+		config = append(config, gaugeMetric(1,
+			"delayed_auto_start", strconv.FormatBool(s.DelayedAutoStart),
+			"description", s.Description,
+			"image_path", s.ImagePath,
+			"name", s.Name,
+			"service_type", serviceTypeName(s.ServiceType),
+			"sid_type", valueOrUnknown(scmSidTypes, s.SidType)))
+
+		for _, d := range s.Dependencies {
+			if d == "" || strings.HasPrefix(d, groupPrefix) {
+				continue
+			}
+			dependency = append(dependency, gaugeMetric(1, "dependency", d, "name", s.Name))
+		}
+
+		if !s.FailureActionsKnown {
+			continue
+		}
+		// the reset period is reported for every service, so the services without failure actions are known
+		period := float64(s.ResetPeriod)
+		if s.ResetPeriod == infiniteResetPeriod {
+			period = math.Inf(1)
+		}
+		resetPeriod = append(resetPeriod, gaugeMetric(period, "name", s.Name))
+		for idx, a := range s.FailureActions {
+			failureAction = append(failureAction, gaugeMetric(1,
+				"action", valueOrUnknown(scmActionTypes, a.Type),
+				"delay_ms", strconv.FormatInt(a.Delay.Milliseconds(), 10),
+				"index", strconv.Itoa(idx),
+				"name", s.Name))
+		}
+	}
+
+	families := make(map[string]*dto.MetricFamily)
+	for _, f := range []struct {
+		name, help string
+		metrics    []*dto.Metric
+	}{
+		{"windows_service_info", "A metric with a constant '1' value labeled with service information", info},
+		{"windows_service_process", "Process of started service", process},
+		{"windows_service_start_mode", "The start mode of the service (StartMode)", startMode},
+		{"windows_service_state", "The state of the service (State)", state},
+		{"windows_service_config", "A metric with a constant '1' value labeled with the service configuration", config},
+		{"windows_service_dependency", "A metric with a constant '1' value for each service the service depends on", dependency},
+		{"windows_service_failure_action", "A metric with a constant '1' value for each action taken when the service fails", failureAction},
+		{"windows_service_failure_reset_period_seconds", "Time without failures after which the failure count of the service is reset", resetPeriod},
+	} {
+		// like the text decoder, families without metrics are not reported
+		if len(f.metrics) > 0 {
+			families[f.name] = &dto.MetricFamily{Name: proto.String(f.name), Help: proto.String(f.help), Type: dto.MetricType_GAUGE.Enum(), Metric: f.metrics}
+		}
+	}
+	return families
+}
+
+func valueOrUnknown(values map[uint32]string, code uint32) string {
+	if v, ok := values[code]; ok {
+		return v
+	}
+	return unknownState
+}
+
+// serviceTypeName describes the service type, prefixed by user_ for the per-user services
+func serviceTypeName(serviceType uint32) string {
+	var name string
+	switch {
+	case serviceType&serviceKernelDriver != 0:
+		return "kernel_driver"
+	case serviceType&serviceFileSystemDriver != 0:
+		return "file_system_driver"
+	case serviceType&serviceOwnProcess != 0:
+		name = "own_process"
+	case serviceType&serviceShareProcess != 0:
+		name = "shared_process"
+	default:
+		return unknownState
+	}
+	if serviceType&serviceUserService != 0 {
+		return "user_" + name
+	}
+	return name
+}
+
+// enumMetrics returns a metric for every value, set to 1 only for the active one
+func enumMetrics(serviceName, label string, values []string, active string) []*dto.Metric {
+	metrics := make([]*dto.Metric, len(values))
+	for i, v := range values {
+		var value float64
+		if v == active {
+			value = 1
+		}
+		metrics[i] = gaugeMetric(value, "name", serviceName, label, v)
+	}
+	return metrics
+}
+
+// gaugeMetric creates a gauge with the labels given as name and value pairs
+func gaugeMetric(value float64, labels ...string) *dto.Metric {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return m
+}
@@ -0,0 +1,166 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// render writes the families in the text format, sorted by name as the exporter does. When names
+// are given only those families are written.
+func render(t *testing.T, families map[string]*dto.MetricFamily, names ...string) string {
+	if len(names) == 0 {
+		for name := range families {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		_, err := expfmt.MetricFamilyToText(&b, families[name])
+		require.NoError(t, err)
+	}
+	return b.String()
+}
+
+// exporterFamilies are the families also served by the exporter
+var exporterFamilies = []string{"windows_service_info", "windows_service_process", "windows_service_start_mode", "windows_service_state"}
+
+func TestSCMFamiliesMatchExporterOutput(t *testing.T) {
+	// testdata/scm.prom is what the exporter serves for the same services
+	expected, err := ioutil.ReadFile("testdata/scm.prom")
+	require.NoError(t, err)
+
+	services := []scmService{
+		{Name: "wuauserv", DisplayName: "Windows Update", RunAs: "LocalSystem", State: 1, StartType: 3},
+		{Name: "spooler", DisplayName: "Print Spooler", RunAs: "LocalSystem", State: 4, StartType: 2, ProcessID: 2620},
+		{Name: "xboxgipsvc", DisplayName: "Xbox Accessory Management Service", RunAs: "LocalSystem", State: 3, StartType: 4},
+		{Name: "dhcp", DisplayName: "DHCP Client", RunAs: `NT Authority\LocalService`, State: 4, StartType: 2, ProcessID: 1468},
+	}
+	assert.Equal(t, string(expected), render(t, scmFamilies(services), exporterFamilies...))
+}
+
+func TestSCMFamiliesUnknownCodes(t *testing.T) {
+	families := scmFamilies([]scmService{{Name: "driver", State: 42, StartType: 42}})
+
+	active := func(family, label string) []string {
+		var values []string
+		for _, m := range families[family].Metric {
+			if m.GetGauge().GetValue() != 1 {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					values = append(values, l.GetValue())
+				}
+			}
+		}
+		return values
+	}
+	assert.Equal(t, []string{"unknown"}, active("windows_service_state", "state"))
+	assert.Empty(t, active("windows_service_start_mode", "start_mode"))
+	assert.Len(t, families["windows_service_start_mode"].Metric, len(exporterStartModes))
+	// no process is reported for services not running
+	assert.NotContains(t, families, "windows_service_process")
+}
+
+func TestSCMFamiliesEmpty(t *testing.T) {
+	assert.Empty(t, scmFamilies(nil))
+}
+
+func TestSCMFamiliesDependencies(t *testing.T) {
+	families := scmFamilies([]scmService{
+		{Name: "lanmanworkstation", State: 4, StartType: 2, Dependencies: []string{"Bowser", "MRxSmb20", "NSI", "+NetworkProvider"}},
+		{Name: "nsi", State: 4, StartType: 2, Dependencies: []string{"rpcss", "nsiproxy"}},
+		{Name: "rpcss", State: 4, StartType: 2},
+	})
+
+	expected := `# HELP windows_service_dependency A metric with a constant '1' value for each service the service depends on
+# TYPE windows_service_dependency gauge
+windows_service_dependency{dependency="Bowser",name="lanmanworkstation"} 1
+windows_service_dependency{dependency="MRxSmb20",name="lanmanworkstation"} 1
+windows_service_dependency{dependency="NSI",name="lanmanworkstation"} 1
+windows_service_dependency{dependency="rpcss",name="nsi"} 1
+windows_service_dependency{dependency="nsiproxy",name="nsi"} 1
+`
+	assert.Equal(t, expected, render(t, families, "windows_service_dependency"))
+}
+
+func TestSCMFamiliesConfig(t *testing.T) {
+	families := scmFamilies([]scmService{
+		{Name: "spooler", State: 4, StartType: 2, ImagePath: `C:\Windows\System32\spoolsv.exe`, Description: "Print Spooler",
+			ServiceType: 0x10, DelayedAutoStart: false, SidType: 1},
+		{Name: "wuauserv", State: 1, StartType: 3, ImagePath: `C:\Windows\system32\svchost.exe -k netsvcs -p`,
+			ServiceType: 0x20, DelayedAutoStart: true, SidType: 0},
+		{Name: "cdpusersvc_1a2b3", State: 4, StartType: 2, ServiceType: 0x60, SidType: 3},
+		{Name: "odd", State: 4, StartType: 2, ServiceType: 0x200, SidType: 2},
+	})
+
+	expected := `# HELP windows_service_config A metric with a constant '1' value labeled with the service configuration
+# TYPE windows_service_config gauge
+windows_service_config{delayed_auto_start="false",description="",image_path="",name="cdpusersvc_1a2b3",service_type="user_shared_process",sid_type="restricted"} 1
+windows_service_config{delayed_auto_start="false",description="",image_path="",name="odd",service_type="unknown",sid_type="unknown"} 1
+windows_service_config{delayed_auto_start="false",description="Print Spooler",image_path="C:\\Windows\\System32\\spoolsv.exe",name="spooler",service_type="own_process",sid_type="unrestricted"} 1
+windows_service_config{delayed_auto_start="true",description="",image_path="C:\\Windows\\system32\\svchost.exe -k netsvcs -p",name="wuauserv",service_type="shared_process",sid_type="none"} 1
+`
+	assert.Equal(t, expected, render(t, families, "windows_service_config"))
+}
+
+func TestServiceTypeName(t *testing.T) {
+	assert.Equal(t, "kernel_driver", serviceTypeName(0x1))
+	assert.Equal(t, "file_system_driver", serviceTypeName(0x2))
+	assert.Equal(t, "own_process", serviceTypeName(0x10))
+	// interactive services have the 0x100 bit set
+	assert.Equal(t, "own_process", serviceTypeName(0x110))
+	assert.Equal(t, "shared_process", serviceTypeName(0x20))
+	assert.Equal(t, "user_own_process", serviceTypeName(0x50))
+	assert.Equal(t, "user_shared_process", serviceTypeName(0xe0))
+	assert.Equal(t, "unknown", serviceTypeName(0))
+}
+
+func TestSCMFamiliesFailureActions(t *testing.T) {
+	families := scmFamilies([]scmService{
+		{Name: "spooler", State: 4, StartType: 2, FailureActionsKnown: true, ResetPeriod: 86400, FailureActions: []scmFailureAction{
+			{Type: 1, Delay: time.Minute},
+			{Type: 1, Delay: 2 * time.Minute},
+			{Type: 0},
+		}},
+		{Name: "eventlog", State: 4, StartType: 2, FailureActionsKnown: true, ResetPeriod: 0xFFFFFFFF, FailureActions: []scmFailureAction{
+			{Type: 2, Delay: 1500 * time.Millisecond},
+			{Type: 3},
+			{Type: 9},
+		}},
+		{Name: "themes", State: 1, StartType: 4, FailureActionsKnown: true},
+		// not reported when the failure actions couldn't be queried
+		{Name: "denied", State: 4, StartType: 2},
+	})
+
+	expected := `# HELP windows_service_failure_action A metric with a constant '1' value for each action taken when the service fails
+# TYPE windows_service_failure_action gauge
+windows_service_failure_action{action="reboot",delay_ms="1500",index="0",name="eventlog"} 1
+windows_service_failure_action{action="run_command",delay_ms="0",index="1",name="eventlog"} 1
+windows_service_failure_action{action="unknown",delay_ms="0",index="2",name="eventlog"} 1
+windows_service_failure_action{action="restart",delay_ms="60000",index="0",name="spooler"} 1
+windows_service_failure_action{action="restart",delay_ms="120000",index="1",name="spooler"} 1
+windows_service_failure_action{action="none",delay_ms="0",index="2",name="spooler"} 1
+# HELP windows_service_failure_reset_period_seconds Time without failures after which the failure count of the service is reset
+# TYPE windows_service_failure_reset_period_seconds gauge
+windows_service_failure_reset_period_seconds{name="eventlog"} +Inf
+windows_service_failure_reset_period_seconds{name="spooler"} 86400
+windows_service_failure_reset_period_seconds{name="themes"} 0
+`
+	assert.Equal(t, expected, render(t, families, "windows_service_failure_action", "windows_service_failure_reset_period_seconds"))
+}
@@ -0,0 +1,110 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package source provides the backends the services metrics are collected from. Only the sources
+// calling the Windows API, exporter.go and scm.go, are restricted to Windows, the rest of the
+// package is tested on every platform.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/log"
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// MetricsSource is a backend the services metrics are fetched from
+type MetricsSource interface {
+	// Start prepares the source, e.g. spawning a process, before the first Fetch
+	Start() error
+	// Fetch returns the current metrics, it's aborted when ctx is cancelled
+	Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error)
+	// Done is closed when the source stops working by itself, e.g. the process it depends on exits.
+	// Sources that can't stop return a nil channel.
+	Done() <-chan struct{}
+	// Stop releases the resources of the source
+	Stop()
+}
+
+// ErrStopped is returned when the source stops before being ready
+var ErrStopped = errors.New("source stopped before being ready")
+
+// URL scrapes an exporter that is not managed by the integration
+type URL struct {
+	url     string
+	client  scraper.HTTPDoer
+	timeout time.Duration
+}
+
+// NewURL creates a source scraping the given URL, each scrape is aborted after timeout so a hung
+// exporter doesn't block the scrapes that follow.
+func NewURL(url string, timeout time.Duration) *URL {
+	return &URL{url: url, client: http.DefaultClient, timeout: timeout}
+}
+
+// Start does nothing since the exporter is not managed by the integration
+func (u *URL) Start() error {
+	return nil
+}
+
+// Fetch scrapes the exporter
+func (u *URL) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	return scrape(ctx, u.client, u.url)
+}
+
+// Done returns nil, the exporter is not managed by the integration
+func (u *URL) Done() <-chan struct{} {
+	return nil
+}
+
+// Stop does nothing since the exporter is not managed by the integration
+func (u *URL) Stop() {}
+
+func scrape(ctx context.Context, client scraper.HTTPDoer, url string) (scraper.MetricFamiliesByName, error) {
+	t := time.Now()
+	log.Debug("Scraping metrics from %s", url)
+
+	metricsByFamily, err := scraper.Get(ctx, client, url)
+	if err != nil {
+		return nil, fmt.Errorf("fail to scrape metrics:%v", err)
+	}
+	log.Debug("Metrics scraped, MetricsByFamily found: %d, time elapsed: %s", len(metricsByFamily), time.Since(t).String())
+	return metricsByFamily, nil
+}
+
+// readyPollInterval is the time between the fetches done by WaitReady
+var readyPollInterval = 500 * time.Millisecond
+
+// WaitReady fetches from the source until it succeeds and returns the metrics. It fails when the
+// source stops running, ctx is cancelled or the timeout expires.
+func WaitReady(ctx context.Context, s MetricsSource, timeout time.Duration) (scraper.MetricFamiliesByName, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		metricsByFamily, err := s.Fetch(ctx)
+		if err == nil {
+			log.Debug("source is ready")
+			return metricsByFamily, nil
+		}
+		log.Debug("source is not ready yet: %v", err)
+
+		select {
+		case <-ticker.C:
+		case <-s.Done():
+			return nil, ErrStopped
+		case <-ctx.Done():
+			return nil, fmt.Errorf("source is not ready:%v", ctx.Err())
+		}
+	}
+}
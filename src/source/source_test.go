@@ -0,0 +1,87 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/newrelic/nri-winservices/src/source/sourcetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var spooler = exportertest.Service{Name: "spooler", DisplayName: "Print Spooler"}
+
+var _ MetricsSource = (*sourcetest.Fake)(nil)
+
+func fakeMetrics(t *testing.T) scraper.MetricFamiliesByName {
+	mfbn, err := scraper.Parse(strings.NewReader(exportertest.Metrics(spooler)))
+	require.NoError(t, err)
+	return mfbn
+}
+
+func TestURLFetch(t *testing.T) {
+	s := exportertest.New(exportertest.OK(spooler), exportertest.Error(http.StatusInternalServerError))
+	defer s.Close()
+
+	u := NewURL(s.MetricsURL(), time.Second)
+	require.NoError(t, u.Start())
+	defer u.Stop()
+	assert.Nil(t, u.Done())
+
+	mfbn, err := u.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, mfbn, "windows_service_info")
+
+	_, err = u.Fetch(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fail to scrape metrics")
+}
+
+func TestURLFetchTimeout(t *testing.T) {
+	s := exportertest.New(exportertest.Slow(time.Minute, spooler))
+	defer s.Close()
+
+	u := NewURL(s.MetricsURL(), 20*time.Millisecond)
+	_, err := u.Fetch(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+}
+
+func TestWaitReady(t *testing.T) {
+	readyPollInterval = time.Millisecond
+	f := sourcetest.NewFake(sourcetest.Result{Err: errors.New("not ready")}, sourcetest.Result{Metrics: fakeMetrics(t)})
+
+	mfbn, err := WaitReady(context.Background(), f, time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, mfbn, "windows_service_info")
+	assert.Equal(t, 2, f.Fetches())
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	readyPollInterval = time.Millisecond
+	f := sourcetest.NewFake(sourcetest.Result{Err: errors.New("not ready")})
+
+	_, err := WaitReady(context.Background(), f, 20*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "source is not ready")
+}
+
+func TestWaitReadyStopped(t *testing.T) {
+	readyPollInterval = time.Hour
+	f := sourcetest.NewFake(sourcetest.Result{Err: errors.New("not ready")})
+	f.Exit()
+
+	_, err := WaitReady(context.Background(), f, time.Second)
+	assert.ErrorIs(t, err, ErrStopped)
+}
@@ -0,0 +1,92 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package sourcetest provides an in-memory metrics source returning scripted results, so the
+// scrape loop can be tested without collecting real metrics.
+package sourcetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/newrelic/nri-winservices/src/scraper"
+)
+
+// Result is a scripted answer of a Fake source
+type Result struct {
+	Metrics scraper.MetricFamiliesByName
+	Err     error
+}
+
+// Fake is an in-memory source returning scripted results in order, the last one is repeated once all
+// have been returned.
+type Fake struct {
+	lock     sync.Mutex
+	results  []Result
+	fetches  int
+	done     chan struct{}
+	exitOnce sync.Once
+	stopped  bool
+}
+
+// NewFake creates a source returning the given results
+func NewFake(results ...Result) *Fake {
+	return &Fake{results: results, done: make(chan struct{})}
+}
+
+// Start does nothing
+func (f *Fake) Start() error {
+	return nil
+}
+
+// Fetch returns the next result
+func (f *Fake) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	idx := f.fetches
+	f.fetches++
+	if len(f.results) == 0 {
+		return scraper.MetricFamiliesByName{}, nil
+	}
+	if idx >= len(f.results) {
+		idx = len(f.results) - 1
+	}
+	return f.results[idx].Metrics, f.results[idx].Err
+}
+
+// Done is closed by Exit
+func (f *Fake) Done() <-chan struct{} {
+	return f.done
+}
+
+// Stop records that the source has been stopped
+func (f *Fake) Stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.stopped = true
+}
+
+// Exit simulates the source stopping by itself
+func (f *Fake) Exit() {
+	f.exitOnce.Do(func() { close(f.done) })
+}
+
+// Fetches returns how many times Fetch has been called
+func (f *Fake) Fetches() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.fetches
+}
+
+// Stopped returns true once Stop has been called
+func (f *Fake) Stopped() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.stopped
+}
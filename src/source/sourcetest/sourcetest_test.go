@@ -0,0 +1,47 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package sourcetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	metrics, err := scraper.Parse(strings.NewReader(exportertest.Metrics(exportertest.Service{Name: "spooler"})))
+	require.NoError(t, err)
+	f := NewFake(Result{Err: errors.New("failed")}, Result{Metrics: metrics})
+	require.NoError(t, f.Start())
+
+	_, err = f.Fetch(context.Background())
+	assert.EqualError(t, err, "failed")
+	for n := 0; n < 2; n++ {
+		mfbn, err := f.Fetch(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, metrics, mfbn)
+	}
+	assert.Equal(t, 3, f.Fetches())
+
+	select {
+	case <-f.Done():
+		t.Fatal("Done closed before Exit")
+	default:
+	}
+	f.Exit()
+	f.Exit()
+	<-f.Done()
+
+	assert.False(t, f.Stopped())
+	f.Stop()
+	assert.True(t, f.Stopped())
+}
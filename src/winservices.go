@@ -1,6 +1,3 @@
-//go:build windows && amd64
-// +build windows,amd64
-
 /*
 * Copyright 2020 New Relic Corporation. All rights reserved.
 * SPDX-License-Identifier: Apache-2.0
@@ -9,34 +6,48 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/newrelic/nri-winservices/src/exporter"
 	"github.com/newrelic/nri-winservices/src/nri"
+	"github.com/newrelic/nri-winservices/src/otlp"
+	"github.com/newrelic/nri-winservices/src/promexport"
+	"github.com/newrelic/nri-winservices/src/scheduler"
 	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/newrelic/nri-winservices/src/sink"
+	"github.com/newrelic/nri-winservices/src/source"
 
 	"github.com/newrelic/infra-integrations-sdk/v4/integration"
 	"github.com/newrelic/infra-integrations-sdk/v4/log"
-
-	//This import is useful merely to keep track of dependency and generate license automatically
-	_ "github.com/prometheus-community/windows_exporter/collector"
 )
 
 type argumentList struct {
-	Version    bool   `default:"false" help:"Print the integration version and commit hash"`
-	Verbose    bool   `default:"false" help:"Print more information to logs."`
-	Pretty     bool   `default:"false" help:"Print pretty formatted JSON."`
-	ConfigPath string `default:"" help:"Path to the config file."`
+	Version        bool   `default:"false" help:"Print the integration version and commit hash"`
+	Verbose        bool   `default:"false" help:"Print more information to logs."`
+	Pretty         bool   `default:"false" help:"Print pretty formatted JSON."`
+	ConfigPath     string `default:"" help:"Path to the config file."`
+	ValidateConfig bool   `default:"false" help:"Validate the config file and exit with a non-zero code if it is not valid."`
+	Once           bool   `default:"false" help:"Run a single scrape cycle and exit, without sending heartbeats."`
 }
 
 const (
 	integrationName = "com.newrelic.winservices"
+	// exporterReadyTimeout is the time the exporter has to start answering in once mode
+	exporterReadyTimeout = 30 * time.Second
 )
 
 var (
+	// shutdownGracePeriod is the time the cycles in progress have to be published once the
+	// integration is stopped, then their scrape is cancelled
+	shutdownGracePeriod = 5 * time.Second
+
 	args               argumentList
 	integrationVersion = "v0.0.0"  // set by -ldflags on build
 	commitHash         = "default" // Commit hash used to build the integration set by -ldflags on build
@@ -44,41 +55,187 @@ var (
 
 type hostnameFn func() (name string, err error)
 
+// Exit codes of the integration, so the reason it stopped can be told apart
+const (
+	exitError           = 1
+	exitConfigError     = 2
+	exitExporterStopped = 3
+	exitSignal          = 4
+)
+
+// errExporterStopped is returned when the local source, usually the spawned exporter, stops running
+var errExporterStopped = errors.New("exporter has stopped")
+
 func main() {
-	i, err := integration.New(integrationName, integrationVersion, integration.Args(&args))
-	fatalOnErr(err)
-	// We want the hostEntity to be created because it's needed for the register_batch endpoint
-	i.HostEntity.SetIgnoreEntity(false)
+	os.Exit(start())
+}
+
+// start runs the integration and returns the exit code. Errors are returned instead of calling
+// log.Fatal so the deferred cleanup, like stopping the exporter, always runs.
+func start() int {
+	// the publisher is the integration writer, it keeps the payload serialized by Publish so it is
+	// sent to every sink once the integration is released. Sinks are added once the config is loaded.
+	publisher := sink.NewPublisher()
+	defer publisher.Close()
 
+	i, err := integration.New(integrationName, integrationVersion, integration.Args(&args), integration.Writer(publisher))
+	if err != nil {
+		log.Error("%v", err)
+		return exitError
+	}
 	log.SetupLogging(args.Verbose)
 
 	v := fmt.Sprintf("integration version: %s commit: %s", integrationVersion, commitHash)
 	if args.Version {
 		fmt.Print(v)
-		return
+		return 0
 	}
 	log.Debug(v)
 
 	config, err := nri.NewConfig(args.ConfigPath)
-	fatalOnErr(err)
+	if args.ValidateConfig {
+		return validateConfig(err)
+	}
+	if err != nil {
+		log.Error("%v", err)
+		return exitConfigError
+	}
+
+	for _, c := range config.Sinks {
+		s, err := sink.New(c)
+		if err != nil {
+			log.Error("%v", err)
+			return exitConfigError
+		}
+		publisher.Add(s, c.Policy)
+	}
+	if config.OTLP != nil {
+		otlpExporter, err := otlp.New(*config.OTLP)
+		if err != nil {
+			log.Error("%v", err)
+			return exitConfigError
+		}
+		publisher.Add(otlpExporter, sink.Policy{})
+	}
+	if config.PrometheusExportAddress != "" {
+		promServer, err := promexport.New(config.PrometheusExportAddress, config.PrometheusExportPath)
+		if err != nil {
+			log.Error("%v", err)
+			return exitConfigError
+		}
+		publisher.Add(promServer, sink.Policy{})
+	}
+
+	// the root context is cancelled on SIGINT and SIGTERM, Windows sends the latter when the
+	// console is closed or the system shuts down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the local source is not started when only remote instances are scraped
+	var local source.MetricsSource
+	if config.ScrapeLocal {
+		if local, err = newLocalSource(config); err != nil {
+			log.Error("%v", err)
+			return exitError
+		}
 
-	e, err := exporter.New(args.Verbose, config.ExporterBindAddress, config.ExporterBindPort)
-	fatalOnErr(err)
+		log.Debug("Starting the %s metrics source", config.MetricsSource)
+		if err = local.Start(); err != nil {
+			log.Error("%v", err)
+			return exitError
+		}
+	}
 
-	log.Debug("Running exporter")
-	err = e.Run()
-	fatalOnErr(err)
+	if args.Once {
+		log.Debug("Running Integration once")
+		if err = runOnce(ctx, local, i, publisher, config, os.Hostname); err == nil {
+			return 0
+		}
+		return exitCode(ctx, err)
+	}
 
-	// After fail the integration is being relaunched by the Agent when timeout expires since no heartbeats are send
+	// After fail the integration is being relaunched by the Agent when timeout expires since no heartbeats are send.
+	// The heartbeats don't depend on the scrape cycles, so run fails when a cycle gets stuck if
+	// max_skipped_scrapes is configured.
 	log.Debug("Running Integration")
-	err = run(e, i, config, os.Hostname)
-	log.Fatal(err)
+	return exitCode(ctx, run(ctx, local, i, publisher, config, os.Hostname))
+}
+
+// exitCode logs the error that stopped the integration and returns the matching exit code
+func exitCode(ctx context.Context, err error) int {
+	switch {
+	case ctx.Err() != nil:
+		log.Info("Signal received, the integration has been stopped")
+		return exitSignal
+	case errors.Is(err, errExporterStopped):
+		log.Error("%v", err)
+		return exitExporterStopped
+	default:
+		log.Error("%v", err)
+		return exitError
+	}
 }
 
-func run(e *exporter.Exporter, i *integration.Integration, config *nri.Config, hostnameFn hostnameFn) error {
-	defer e.Kill()
+// run collects the metrics of the local source and the instances until an error happens or ctx is
+// cancelled. Before returning, no new cycle is started, the cycles in progress are given
+// shutdownGracePeriod to publish their payload and the local source is stopped. local is nil when
+// only remote instances are scraped.
+func run(ctx context.Context, local source.MetricsSource, i *integration.Integration, publisher *sink.Publisher, config *nri.Config, hostnameFn hostnameFn) error {
+	// the integration is shared by all the scrape loops, the lock serializes its use. It isn't held
+	// while the payload is written to the sinks, so a slow sink doesn't delay the heartbeats.
+	var lock sync.Mutex
+	errs := make(chan error, len(config.Instances)+1)
+	// deferred in reverse order: the loops are stopped and waited before stopping the local source
+	if local != nil {
+		defer local.Stop()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// the cycles outlive ctx for the grace period, so the scrape in progress is not thrown away
+	cycleCtx, cancelCycles := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelCycles()
+	// read before starting the goroutine, which can outlive run
+	gracePeriod := shutdownGracePeriod
+	go func() {
+		<-ctx.Done()
+		grace := time.NewTimer(gracePeriod)
+		defer grace.Stop()
+		select {
+		case <-grace.C:
+			log.Warn("the scrape cycles in progress didn't finish in %s, cancelling them", gracePeriod)
+			cancelCycles()
+		case <-cycleCtx.Done():
+		}
+	}()
+	var loops sync.WaitGroup
+	defer loops.Wait()
+	defer cancel()
+	for _, instance := range config.Instances {
+		loops.Add(1)
+		go func(instance *nri.Config) {
+			defer loops.Done()
+			runInstance(ctx, cycleCtx, i, publisher, &lock, instance, errs)
+		}(instance)
+	}
+
 	heartBeat := time.NewTicker(config.HeartBeatPeriod)
-	metricInterval := time.NewTicker(config.ScrapeInterval)
+	defer heartBeat.Stop()
+	var localDone <-chan struct{}
+	if local != nil {
+		localDone = local.Done()
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			s := newScheduler(config)
+			err := s.Run(ctx.Done(), func() {
+				if err := scrapeLocal(cycleCtx, local, i, publisher, &lock, config, hostnameFn, s.Skipped); err != nil {
+					sendErr(ctx, errs, err)
+				}
+			})
+			if err != nil {
+				sendErr(ctx, errs, err)
+			}
+		}()
+	}
 
 	for {
 		select {
@@ -86,44 +243,176 @@ func run(e *exporter.Exporter, i *integration.Integration, config *nri.Config, h
 			log.Debug("Sending heartBeat")
 			// hart beat signal for long running integrations
 			// https://docs.newrelic.com/docs/integrations/integrations-sdk/file-specifications/host-integrations-newer-configuration-format#timeout
+			// the heartbeats are meant for the Agent, so they are written to stdout and not to the
+			// sinks, the config makes sure stdout is one of them. Like the stdout sink they are written
+			// with a single call, which os.Stdout doesn't interleave with the payloads.
 			fmt.Println("{}")
 
-		case <-metricInterval.C:
-			t := time.Now()
-			log.Debug("Scraping and publishing metrics")
+		case err := <-errs:
+			return err
 
-			metricsByFamily, err := scraper.Get(http.DefaultClient, "http://"+e.URL+e.MetricPath)
-			if err != nil {
-				return fmt.Errorf("fail to scrape metrics:%v", err)
-			}
-			log.Debug("Metrics scraped, MetricsByFamily found: %d, time elapsed: %s", len(metricsByFamily), time.Since(t).String())
+		case <-localDone:
+			log.Debug("The exporter is not running anymore, the integration is going to be stopped")
+			// exit when the exporter has stopped running
+			return errExporterStopped
 
-			hostname, err := hostnameFn()
-			if err != nil {
-				return fmt.Errorf("fail to get the hostname:%v", err)
-			}
+		case <-ctx.Done():
+			log.Debug("Stopping the integration")
+			return ctx.Err()
+		}
+	}
+}
 
-			if err = nri.ProcessMetrics(i, metricsByFamily, config.Matcher, hostname); err != nil {
-				return fmt.Errorf("fail to process metrics:%v", err)
-			}
-			log.Debug("Metrics processed, entities found: %d, time elapsed: %s", len(i.Entities), time.Since(t).String())
+// runInstance scrapes a remote exporter until ctx is cancelled, the cycles are run with cycleCtx. Since
+// the exporter is not managed by the integration scrape failures are only logged, processing and
+// publishing failures are sent to errs.
+func runInstance(ctx, cycleCtx context.Context, i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, config *nri.Config, errs chan<- error) {
+	// a scrape can't take longer than the interval, the next one would be skipped anyway
+	remote := source.NewURL(config.ExporterURL, config.ScrapeInterval)
+	err := newScheduler(config).Run(ctx.Done(), func() {
+		if err := scrapeInstance(cycleCtx, remote, i, publisher, lock, config); err != nil {
+			sendErr(ctx, errs, err)
+		}
+	})
+	if err != nil {
+		sendErr(ctx, errs, fmt.Errorf("instance %s: %v", config.Hostname, err))
+	}
+}
 
-			err = i.Publish()
-			if err != nil {
-				log.Error("failed to publish integration:%v", err)
-			}
-			log.Debug("Metrics published")
+// runOnce waits for the local source to be ready and runs a single cycle for it and for every instance.
+// No heartbeat is sent, so it can be scheduled by the Agent as a short running integration. Unlike the
+// scrape loop, an instance that can't be scraped makes it fail once the other instances are published.
+func runOnce(ctx context.Context, local source.MetricsSource, i *integration.Integration, publisher *sink.Publisher, config *nri.Config, hostnameFn hostnameFn) error {
+	var lock sync.Mutex
+	if local != nil {
+		defer local.Stop()
+		metricsByFamily, err := source.WaitReady(ctx, local, exporterReadyTimeout)
+		if errors.Is(err, source.ErrStopped) {
+			return errExporterStopped
+		}
+		if err != nil {
+			return err
+		}
+		if err = publishLocal(ctx, i, publisher, &lock, metricsByFamily, config, hostnameFn, nil); err != nil {
+			return err
+		}
+	}
 
-		case <-e.Done:
-			log.Debug("The exporter is not running anymore, the integration is going to be stopped")
-			// exit when the exporter has stopped running
-			return fmt.Errorf("exporter has stopped")
+	var failed []string
+	for _, instance := range config.Instances {
+		metricsByFamily, err := source.NewURL(instance.ExporterURL, instance.ScrapeInterval).Fetch(ctx)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("instance %s: %v", instance.Hostname, err))
+			continue
+		}
+		if err = processAndPublish(ctx, i, publisher, &lock, metricsByFamily, instance, instance.Hostname, nil); err != nil {
+			return fmt.Errorf("instance %s: %v", instance.Hostname, err)
 		}
 	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// scrapeLocal runs a cycle for the local source, skipped returns the ticks skipped by its scheduler.
+// Like the instances, a scrape can't take longer than the interval. The payload is published with ctx.
+func scrapeLocal(ctx context.Context, local source.MetricsSource, i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, config *nri.Config, hostnameFn hostnameFn, skipped func() uint64) error {
+	scrapeCtx, cancel := context.WithTimeout(ctx, config.ScrapeInterval)
+	defer cancel()
+	metricsByFamily, err := local.Fetch(scrapeCtx)
+	if err != nil {
+		return err
+	}
+	return publishLocal(ctx, i, publisher, lock, metricsByFamily, config, hostnameFn, skipped)
+}
+
+// publishLocal processes the metrics of the local source, reported for the host the integration runs on
+func publishLocal(ctx context.Context, i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, metricsByFamily scraper.MetricFamiliesByName, config *nri.Config, hostnameFn hostnameFn, skipped func() uint64) error {
+	hostname, err := hostnameFn()
+	if err != nil {
+		return fmt.Errorf("fail to get the hostname:%v", err)
+	}
+
+	return processAndPublish(ctx, i, publisher, lock, metricsByFamily, config, hostname, skipped)
+}
+
+// scrapeInstance runs a cycle for a remote exporter. Since the exporter is not managed by the
+// integration scrape failures are only logged.
+func scrapeInstance(ctx context.Context, remote source.MetricsSource, i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, config *nri.Config) error {
+	metricsByFamily, err := remote.Fetch(ctx)
+	if err != nil {
+		log.Error("instance %s: %v", config.Hostname, err)
+		return nil
+	}
+	if err = processAndPublish(ctx, i, publisher, lock, metricsByFamily, config, config.Hostname, nil); err != nil {
+		return fmt.Errorf("instance %s: %v", config.Hostname, err)
+	}
+	return nil
+}
+
+func newScheduler(config *nri.Config) *scheduler.Scheduler {
+	return scheduler.New(scheduler.Config{
+		Interval:   config.ScrapeInterval,
+		Jitter:     config.ScrapeJitter,
+		Align:      config.ScrapeAlign,
+		MaxSkipped: config.MaxSkippedScrapes,
+	})
+}
+
+// sendErr reports the error of a scrape cycle unless run has already returned
+func sendErr(ctx context.Context, errs chan<- error, err error) {
+	select {
+	case errs <- err:
+	case <-ctx.Done():
+	}
+}
+
+// processAndPublish publishes the metrics, the writes to the sinks are aborted when ctx is cancelled.
+// skipped is nil when the cycle is not run by a scheduler or the summary of the host is not reported.
+func processAndPublish(ctx context.Context, i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, metricsByFamily scraper.MetricFamiliesByName, config *nri.Config, hostname string, skipped func() uint64) error {
+	payload, err := processMetrics(i, publisher, lock, metricsByFamily, config, hostname, skipped)
+	if err != nil {
+		return err
+	}
+
+	// failures of sinks not marked as required are logged by the publisher. The payload
+	// doesn't tell the host when no service matches, so the sinks get it from the context.
+	if err = publisher.Publish(sink.WithHostname(ctx, hostname), payload); err != nil {
+		return fmt.Errorf("failed to publish integration:%v", err)
+	}
+	log.Debug("Metrics published")
+	return nil
+}
+
+// processMetrics adds the metrics to the integration and returns the payload serialized by it. The
+// lock is only held while the integration is used.
+func processMetrics(i *integration.Integration, publisher *sink.Publisher, lock *sync.Mutex, metricsByFamily scraper.MetricFamiliesByName, config *nri.Config, hostname string, skipped func() uint64) ([]byte, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	t := time.Now()
+	if err := nri.ProcessMetrics(i, metricsByFamily, config, hostname); err != nil {
+		return nil, fmt.Errorf("fail to process metrics:%v", err)
+	}
+	log.Debug("Metrics processed, entities found: %d, time elapsed: %s", len(i.Entities), time.Since(t).String())
+	if skipped != nil {
+		nri.AddSkippedScrapes(i, hostname, skipped())
+	}
+
+	// the publisher keeps the payload written by the integration until it is taken
+	if err := i.Publish(); err != nil {
+		return nil, fmt.Errorf("failed to serialize the payload:%v", err)
+	}
+	return publisher.Take(), nil
 }
 
-func fatalOnErr(err error) {
+// validateConfig reports the result of loading the config and returns the exit code
+func validateConfig(err error) int {
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "config %s is not valid: %v\n", args.ConfigPath, err)
+		return exitConfigError
 	}
+	fmt.Printf("config %s is valid\n", args.ConfigPath)
+	return 0
 }
@@ -0,0 +1,456 @@
+/*
+* Copyright 2020 New Relic Corporation. All rights reserved.
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v4/integration"
+	"github.com/newrelic/nri-winservices/src/exporter/exportertest"
+	"github.com/newrelic/nri-winservices/src/matcher"
+	"github.com/newrelic/nri-winservices/src/nri"
+	"github.com/newrelic/nri-winservices/src/scheduler"
+	"github.com/newrelic/nri-winservices/src/scraper"
+	"github.com/newrelic/nri-winservices/src/sink"
+	"github.com/newrelic/nri-winservices/src/source"
+	"github.com/newrelic/nri-winservices/src/source/sourcetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInterval = 10 * time.Millisecond
+
+var spooler = exportertest.Service{Name: "spooler", DisplayName: "Print Spooler", ProcessID: 1234}
+
+// payloads is a sink keeping what is published by the integration
+type payloads struct {
+	lock      sync.Mutex
+	buf       bytes.Buffer
+	hostnames []string
+}
+
+func (p *payloads) Name() string { return "payloads" }
+
+func (p *payloads) Write(ctx context.Context, b []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hostnames = append(p.hostnames, sink.Hostname(ctx))
+	_, err := p.buf.Write(b)
+	return err
+}
+
+func (p *payloads) Close() error { return nil }
+
+// states returns the state reported for the service entity in every payload published
+func (p *payloads) states(t *testing.T) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var states []string
+	dec := json.NewDecoder(bytes.NewReader(p.buf.Bytes()))
+	for dec.More() {
+		var payload struct {
+			Data []struct {
+				Entity  *struct{ Name string }
+				Metrics []struct {
+					Name       string
+					Attributes map[string]string
+				}
+			}
+		}
+		require.NoError(t, dec.Decode(&payload))
+		for _, d := range payload.Data {
+			if d.Entity == nil {
+				continue
+			}
+			for _, m := range d.Metrics {
+				if m.Name == "windows_service_state" {
+					states = append(states, m.Attributes["state"])
+				}
+			}
+		}
+	}
+	return states
+}
+
+// hostMetric returns the values of a metric of the host entity in every payload published
+func (p *payloads) hostMetric(t *testing.T, name string) []float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var values []float64
+	dec := json.NewDecoder(bytes.NewReader(p.buf.Bytes()))
+	for dec.More() {
+		var payload struct {
+			Data []struct {
+				Entity  *struct{ Name string }
+				Metrics []struct {
+					Name  string
+					Value float64
+				}
+			}
+		}
+		require.NoError(t, dec.Decode(&payload))
+		for _, d := range payload.Data {
+			if d.Entity != nil {
+				continue
+			}
+			for _, m := range d.Metrics {
+				if m.Name == name {
+					values = append(values, m.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func newTestIntegration(t *testing.T) (*integration.Integration, *sink.Publisher, *payloads) {
+	p := &payloads{}
+	publisher := sink.NewPublisher()
+	publisher.Add(p, sink.Policy{Required: true})
+	i, err := integration.New(integrationName, integrationVersion, integration.Writer(publisher))
+	require.NoError(t, err)
+	return i, publisher, p
+}
+
+func newTestConfig(t *testing.T) *nri.Config {
+	m, err := matcher.New([]string{`regex ".*"`})
+	require.NoError(t, err)
+	return &nri.Config{
+		Matcher:         m,
+		ScrapeInterval:  testInterval,
+		HeartBeatPeriod: time.Hour,
+		ScrapeLocal:     true,
+	}
+}
+
+// parseMetrics returns the metrics the exporter would serve for the services
+func parseMetrics(t *testing.T, services ...exportertest.Service) scraper.MetricFamiliesByName {
+	mfbn, err := scraper.Parse(strings.NewReader(exportertest.Metrics(services...)))
+	require.NoError(t, err)
+	return mfbn
+}
+
+func testHostname() (string, error) {
+	return "test-host", nil
+}
+
+// stubbornSource calls wait on every Fetch ignoring the cancellation of the scrape, like a cycle
+// stuck out of the control of its context
+type stubbornSource struct {
+	source.MetricsSource
+	wait func()
+}
+
+func (s stubbornSource) Fetch(ctx context.Context) (scraper.MetricFamiliesByName, error) {
+	s.wait()
+	return s.MetricsSource.Fetch(context.Background())
+}
+
+func TestRunInstanceSurvivesFailingScrapes(t *testing.T) {
+	stopped := spooler
+	stopped.State = "stopped"
+	s := exportertest.New(
+		exportertest.OK(spooler),
+		exportertest.Error(http.StatusInternalServerError),
+		exportertest.Malformed(),
+		exportertest.OK(stopped),
+	)
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	instance := newTestConfig(t)
+	instance.ExporterURL = s.MetricsURL()
+	instance.Hostname = "remote"
+	config := &nri.Config{HeartBeatPeriod: time.Hour, Instances: []*nri.Config{instance}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, nil, i, publisher, config, testHostname) }()
+
+	require.Eventually(t, func() bool { return s.Requests() >= 6 }, 5*time.Second, testInterval)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// failed scrapes are skipped and the service change is reported
+	states := p.states(t)
+	require.GreaterOrEqual(t, len(states), 3)
+	assert.Equal(t, "running", states[0])
+	assert.Equal(t, "stopped", states[1])
+	assert.Equal(t, "stopped", states[len(states)-1])
+	// the sinks are told the host of the instance
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, hostname := range p.hostnames {
+		assert.Equal(t, "remote", hostname)
+	}
+}
+
+func TestRunStopsOnLocalScrapeFailure(t *testing.T) {
+	s := exportertest.New(exportertest.OK(spooler), exportertest.Error(http.StatusInternalServerError))
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	err := run(context.Background(), source.NewURL(s.MetricsURL(), time.Hour), i, publisher, newTestConfig(t), testHostname)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "500 Internal Server Error"), err.Error())
+	assert.Equal(t, []string{"running"}, p.states(t))
+}
+
+func TestRunStopsOnMalformedMetrics(t *testing.T) {
+	s := exportertest.New(exportertest.Malformed())
+	defer s.Close()
+
+	i, publisher, _ := newTestIntegration(t)
+	err := run(context.Background(), source.NewURL(s.MetricsURL(), time.Hour), i, publisher, newTestConfig(t), testHostname)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fail to scrape metrics")
+}
+
+func TestRunCancelsSlowScrape(t *testing.T) {
+	defer func(d time.Duration) { shutdownGracePeriod = d }(shutdownGracePeriod)
+	shutdownGracePeriod = 5 * testInterval
+	s := exportertest.New(exportertest.Slow(time.Minute, spooler))
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	config := newTestConfig(t)
+	// the scrape is cancelled at the end of the grace period, before its deadline
+	config.ScrapeInterval = 20 * testInterval
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, source.NewURL(s.MetricsURL(), time.Hour), i, publisher, config, testHostname) }()
+
+	require.Eventually(t, func() bool { return s.Requests() >= 1 }, 5*time.Second, testInterval)
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after the context was cancelled")
+	}
+	assert.Empty(t, p.states(t))
+}
+
+func TestRunPublishesScrapeInProgress(t *testing.T) {
+	s := exportertest.New(exportertest.Slow(10*testInterval, spooler))
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	config := newTestConfig(t)
+	config.ScrapeInterval = 20 * testInterval
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, source.NewURL(s.MetricsURL(), time.Hour), i, publisher, config, testHostname) }()
+
+	require.Eventually(t, func() bool { return s.Requests() >= 1 }, 5*time.Second, testInterval)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	// the scrape in progress when the signal is received finishes within the grace period
+	assert.Equal(t, []string{"running"}, p.states(t))
+}
+
+// stuckSink blocks every write until its context is cancelled, writes receives the first one
+type stuckSink struct {
+	writes chan struct{}
+}
+
+func (s stuckSink) Name() string { return "stuck" }
+
+func (s stuckSink) Write(ctx context.Context, _ []byte) error {
+	select {
+	case s.writes <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s stuckSink) Close() error { return nil }
+
+func TestRunCancelsStuckSink(t *testing.T) {
+	defer func(d time.Duration) { shutdownGracePeriod = d }(shutdownGracePeriod)
+	shutdownGracePeriod = 5 * testInterval
+	local := sourcetest.NewFake(sourcetest.Result{Metrics: parseMetrics(t, spooler)})
+
+	i, publisher, _ := newTestIntegration(t)
+	stuck := stuckSink{writes: make(chan struct{}, 1)}
+	publisher.Add(stuck, sink.Policy{Retries: 3, RetryDelay: time.Minute, Required: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, local, i, publisher, newTestConfig(t), testHostname) }()
+
+	<-stuck.writes
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return while a sink was stuck")
+	}
+}
+
+func TestRunNotDelayedBySlowOptionalSink(t *testing.T) {
+	local := sourcetest.NewFake(sourcetest.Result{Metrics: parseMetrics(t, spooler)})
+
+	i, publisher, p := newTestIntegration(t)
+	stuck := stuckSink{writes: make(chan struct{}, 1)}
+	publisher.Add(stuck, sink.Policy{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, local, i, publisher, newTestConfig(t), testHostname) }()
+
+	// the cycles keep being published while the optional sink is stuck
+	<-stuck.writes
+	require.Eventually(t, func() bool { return len(p.states(t)) >= 5 }, 5*time.Second, testInterval)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	publisher.Close()
+}
+
+func TestRunTimesOutLocalScrape(t *testing.T) {
+	s := exportertest.New(exportertest.Slow(time.Minute, spooler))
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	done := make(chan error)
+	go func() {
+		done <- run(context.Background(), source.NewURL(s.MetricsURL(), time.Hour), i, publisher, newTestConfig(t), testHostname)
+	}()
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "context deadline exceeded")
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return while the exporter was stuck")
+	}
+	assert.Empty(t, p.states(t))
+}
+
+func TestRunStopsWhenCycleIsStalled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	local := stubbornSource{MetricsSource: sourcetest.NewFake(), wait: func() { <-release }}
+
+	i, publisher, _ := newTestIntegration(t)
+	config := newTestConfig(t)
+	config.MaxSkippedScrapes = 5
+	done := make(chan error)
+	go func() { done <- run(context.Background(), local, i, publisher, config, testHostname) }()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, scheduler.ErrStalled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return while the cycle was stuck")
+	}
+}
+
+func TestRunReportsSkippedScrapes(t *testing.T) {
+	// the cycles make a few ticks be skipped, the integration keeps running
+	local := stubbornSource{
+		MetricsSource: sourcetest.NewFake(sourcetest.Result{Metrics: parseMetrics(t, spooler)}),
+		wait:          func() { time.Sleep(3 * testInterval) },
+	}
+
+	i, publisher, p := newTestIntegration(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, local, i, publisher, newTestConfig(t), testHostname) }()
+
+	require.Eventually(t, func() bool {
+		skipped := p.hostMetric(t, "windows_services_skipped_scrapes_count")
+		return len(skipped) >= 2 && skipped[len(skipped)-1] > 0
+	}, 5*time.Second, testInterval)
+	cancel()
+	<-done
+}
+
+func TestRunExporterStopped(t *testing.T) {
+	local := sourcetest.NewFake(sourcetest.Result{Metrics: parseMetrics(t, spooler)})
+	local.Exit()
+	i, publisher, _ := newTestIntegration(t)
+	config := newTestConfig(t)
+	config.ScrapeInterval = time.Hour
+
+	err := run(context.Background(), local, i, publisher, config, testHostname)
+	assert.ErrorIs(t, err, errExporterStopped)
+	assert.True(t, local.Stopped())
+}
+
+func TestRunFakeSource(t *testing.T) {
+	stopped := spooler
+	stopped.State = "stopped"
+	local := sourcetest.NewFake(
+		sourcetest.Result{Metrics: parseMetrics(t, spooler)},
+		sourcetest.Result{Metrics: parseMetrics(t, stopped)},
+		sourcetest.Result{Err: errors.New("fetch failed")},
+	)
+	i, publisher, p := newTestIntegration(t)
+
+	err := run(context.Background(), local, i, publisher, newTestConfig(t), testHostname)
+	assert.EqualError(t, err, "fetch failed")
+	assert.Equal(t, 3, local.Fetches())
+	assert.True(t, local.Stopped())
+	assert.Equal(t, []string{"running", "stopped"}, p.states(t))
+}
+
+func TestRunOnce(t *testing.T) {
+	s := exportertest.New(exportertest.Error(http.StatusServiceUnavailable), exportertest.OK(spooler))
+	defer s.Close()
+
+	i, publisher, p := newTestIntegration(t)
+	err := runOnce(context.Background(), source.NewURL(s.MetricsURL(), time.Hour), i, publisher, newTestConfig(t), testHostname)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"running"}, p.states(t))
+}
+
+func TestRunOnceInstanceFailure(t *testing.T) {
+	failing := exportertest.New(exportertest.Error(http.StatusServiceUnavailable))
+	defer failing.Close()
+	working := exportertest.New(exportertest.OK(spooler))
+	defer working.Close()
+
+	instance := func(hostname, url string) *nri.Config {
+		c := newTestConfig(t)
+		c.Hostname = hostname
+		c.ExporterURL = url
+		return c
+	}
+	config := &nri.Config{Instances: []*nri.Config{
+		instance("sql-01", failing.MetricsURL()),
+		instance("web-01", working.MetricsURL()),
+	}}
+
+	i, publisher, p := newTestIntegration(t)
+	err := runOnce(context.Background(), nil, i, publisher, config, testHostname)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "instance sql-01: fail to scrape metrics")
+	// the instances that can be scraped are still published
+	assert.Equal(t, []string{"running"}, p.states(t))
+	assert.Equal(t, exitError, exitCode(context.Background(), err))
+}
+
+func TestRunOnceSourceStopped(t *testing.T) {
+	local := sourcetest.NewFake(sourcetest.Result{Err: errors.New("not ready")})
+	local.Exit()
+	i, publisher, p := newTestIntegration(t)
+
+	err := runOnce(context.Background(), local, i, publisher, newTestConfig(t), testHostname)
+	assert.ErrorIs(t, err, errExporterStopped)
+	assert.True(t, local.Stopped())
+	assert.Empty(t, p.states(t))
+}